@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/bot"
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/conversation"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/inlinecache"
+	"corp-bullshifter/internal/invoice"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+	"corp-bullshifter/internal/stt"
+	"corp-bullshifter/internal/workerpool"
+)
+
+// dispatcher routes Telegram updates to their handlers on a bounded worker pool.
+// Polling and webhook modes feed it the same way.
+type dispatcher struct {
+	bot             *tgbotapi.BotAPI
+	httpClient      *http.Client
+	cfg             *config.Config
+	store           *storage.Storage
+	limiter         *ratelimit.Limiter
+	claudeClient    *claude.Client
+	draftStore      *drafts.Store
+	conversations   *conversation.Store
+	inlineCache     *inlinecache.Cache
+	inlineDebouncer *bot.InlineDebouncer
+	pool            *workerpool.Pool
+	invoices        *invoice.Signer
+	transcriber     stt.Transcriber // nil when voice messages are disabled
+
+	// stopping is done once a stop signal arrives, so a full pool stops blocking dispatch
+	stopping context.Context
+}
+
+// dispatch queues a single update. Updates from the same user run one at a time
+// in arrival order; inline and pre-checkout queries run independently because
+// they must be answered quickly. Inline queries are only queued once the user
+// stops typing, so keystrokes don't hold workers while they wait.
+func (d *dispatcher) dispatch(update tgbotapi.Update) {
+	key, job := d.route(update)
+	if job == nil {
+		return
+	}
+
+	if query := update.InlineQuery; query != nil {
+		d.inlineDebouncer.Debounce(query, func() {
+			d.submit(update.UpdateID, key, job)
+		})
+		return
+	}
+
+	d.submit(update.UpdateID, key, job)
+}
+
+// submit queues a routed update on the worker pool
+func (d *dispatcher) submit(updateID int, key string, job workerpool.Job) {
+	if err := d.pool.Submit(d.stopping, key, job); err != nil {
+		log.Printf("Dropping update %d: %v", updateID, err)
+	}
+}
+
+// route picks the queue key and the handler for an update; job is nil for ignored updates
+func (d *dispatcher) route(update tgbotapi.Update) (string, workerpool.Job) {
+	if query := update.PreCheckoutQuery; query != nil {
+		return "precheckout:" + query.ID, func(ctx context.Context) {
+			bot.HandlePreCheckout(ctx, d.bot, query, d.store, d.invoices)
+		}
+	}
+
+	if query := update.InlineQuery; query != nil {
+		return "inline:" + query.ID, func(ctx context.Context) {
+			bot.HandleInlineQuery(ctx, d.bot, query, d.store, d.limiter, d.claudeClient, d.inlineCache)
+		}
+	}
+
+	if query := update.CallbackQuery; query != nil {
+		return userKey(query.From.ID), func(ctx context.Context) {
+			bot.HandleCallbackQuery(ctx, d.bot, query, d.httpClient, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore, d.invoices)
+		}
+	}
+
+	message := update.Message
+	if message == nil {
+		return "", nil
+	}
+
+	key := fmt.Sprintf("chat:%d", message.Chat.ID)
+	if message.From != nil {
+		key = userKey(message.From.ID)
+	}
+
+	if message.SuccessfulPayment != nil {
+		return key, func(ctx context.Context) {
+			bot.HandleSuccessfulPayment(ctx, d.bot, message, d.cfg, d.store, d.invoices)
+		}
+	}
+
+	// Group chats only react to mentions, /polish and trigger words
+	if bot.IsGroupChat(message.Chat) {
+		return key, func(ctx context.Context) {
+			bot.HandleGroupMessage(ctx, d.bot, message, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore)
+		}
+	}
+
+	// Handle commands
+	if message.IsCommand() {
+		return key, func(ctx context.Context) {
+			switch message.Command() {
+			case "start":
+				bot.HandleStart(d.bot, message)
+			case "help":
+				bot.HandleHelp(d.bot, message)
+			case "stats":
+				bot.HandleStats(ctx, d.bot, message, d.limiter, d.store)
+			case "new":
+				bot.HandleNew(ctx, d.bot, message, d.conversations)
+			case "decode":
+				bot.HandleDecode(ctx, d.bot, message, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore)
+			case "style":
+				bot.HandleStyle(ctx, d.bot, message, d.store)
+			case "timezone":
+				bot.HandleTimezone(ctx, d.bot, message, d.store, d.limiter)
+			case "refund":
+				bot.HandleRefund(ctx, d.bot, message, d.cfg, d.store)
+			case "subscribe":
+				bot.HandleSubscribe(ctx, d.bot, message, d.store)
+			default:
+				msg := tgbotapi.NewMessage(message.Chat.ID,
+					"Unknown command. Use /help to see available commands.")
+				d.bot.Send(msg)
+			}
+		}
+	}
+
+	// Transcribe and rewrite voice messages and audio files
+	if (message.Voice != nil || message.Audio != nil) && message.From != nil {
+		return key, func(ctx context.Context) {
+			bot.HandleVoice(ctx, d.bot, message, d.httpClient, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore, d.transcriber)
+		}
+	}
+
+	// Draft replies to screenshots
+	if len(message.Photo) > 0 && message.From != nil {
+		return key, func(ctx context.Context) {
+			bot.HandlePhoto(ctx, d.bot, message, d.httpClient, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore)
+		}
+	}
+
+	// Rewrite uploaded documents
+	if message.Document != nil && message.From != nil {
+		return key, func(ctx context.Context) {
+			bot.HandleDocument(ctx, d.bot, message, d.httpClient, d.cfg, d.store, d.limiter, d.claudeClient)
+		}
+	}
+
+	// Handle text messages
+	if message.Text != "" {
+		return key, func(ctx context.Context) {
+			bot.HandleTextMessage(ctx, d.bot, message, d.httpClient, d.cfg, d.store, d.limiter, d.claudeClient, d.draftStore, d.conversations)
+		}
+	}
+
+	return "", nil
+}
+
+// userKey is the queue key that serializes one user's updates
+func userKey(telegramID int64) string {
+	return fmt.Sprintf("user:%d", telegramID)
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"net/url"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -10,10 +15,20 @@ import (
 	"corp-bullshifter/internal/bot"
 	"corp-bullshifter/internal/claude"
 	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/conversation"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/inlinecache"
+	"corp-bullshifter/internal/invoice"
 	"corp-bullshifter/internal/ratelimit"
 	"corp-bullshifter/internal/storage"
+	"corp-bullshifter/internal/stt"
+	"corp-bullshifter/internal/webhook"
+	"corp-bullshifter/internal/workerpool"
 )
 
+// claudeResponseHeaderTimeout bounds the wait for Claude to start answering
+const claudeResponseHeaderTimeout = 30 * time.Second
+
 func main() {
 	log.Println("Starting Corporate Bullshifter bot...")
 
@@ -22,13 +37,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
-	log.Printf("Configuration loaded. Using Claude model: %s", cfg.ClaudeModel)
+	log.Printf("Configuration loaded. Using Claude models: %s", strings.Join(cfg.ClaudeModels, " → "))
 
 	// Initialize HTTP client
 	httpClient := &http.Client{
 		Timeout: 30 * time.Second,
 	}
 
+	// Claude responses are streamed for longer than any fixed client timeout allows;
+	// every call carries its own context deadline, and only a slow first byte is cut short
+	claudeTransport := http.DefaultTransport.(*http.Transport).Clone()
+	claudeTransport.ResponseHeaderTimeout = claudeResponseHeaderTimeout
+	claudeHTTPClient := &http.Client{Transport: claudeTransport}
+
 	// Initialize Telegram bot
 	telegramBot, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
 	if err != nil {
@@ -45,17 +66,115 @@ func main() {
 	log.Println("PostgreSQL storage initialized")
 
 	// Initialize Redis rate limiter
-	limiter, err := ratelimit.New(cfg.RedisURL, config.DailyTokenLimit)
+	policy := ratelimit.Policy{
+		DailyTokens:       config.DailyTokenLimit,
+		RequestsPerMinute: cfg.RequestsPerMinute,
+		MaxMessageLength:  cfg.MaxMessageLength,
+		MaxConcurrent:     cfg.MaxConcurrentRequests,
+	}
+	limiter, err := ratelimit.New(cfg.RedisURL, policy)
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	defer limiter.Close()
-	log.Printf("Redis rate limiter initialized. Daily limit: %d tokens per user", config.DailyTokenLimit)
+	log.Printf("Redis rate limiter initialized. Daily limit: %d tokens, %d requests/min, %d chars/message, %d concurrent per user",
+		policy.DailyTokens, policy.RequestsPerMinute, policy.MaxMessageLength, policy.MaxConcurrent)
+
+	// Initialize draft store for the rewrite action buttons
+	draftStore, err := drafts.New(cfg.RedisURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer draftStore.Close()
+
+	// Initialize conversation history for follow-up instructions
+	conversations, err := conversation.New(cfg.RedisURL, cfg.ConversationTTL, config.ConversationMaxTurns)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer conversations.Close()
+
+	// Initialize inline query cache
+	inlineCache, err := inlinecache.New(cfg.RedisURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer inlineCache.Close()
 
 	// Initialize Claude API client
-	claudeClient := claude.New(cfg.ClaudeAPIKey, cfg.ClaudeAPIURL, cfg.ClaudeModel, httpClient)
+	claudeClient := claude.New(cfg.ClaudeAPIKey, cfg.ClaudeAPIURL, cfg.ClaudeModels, claudeHTTPClient)
 	log.Println("Claude API client initialized")
 
+	// Initialize speech-to-text for voice messages
+	transcriber := newTranscriber(cfg)
+
+	// Updates are handled on a bounded worker pool
+	pool := workerpool.New(cfg.WorkerPoolSize, cfg.WorkerQueueSize)
+	log.Printf("Worker pool started: %d workers, queue size %d", cfg.WorkerPoolSize, cfg.WorkerQueueSize)
+
+	// A stop signal also unblocks updates waiting for room in a full pool
+	stopping, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	d := &dispatcher{
+		bot:             telegramBot,
+		httpClient:      httpClient,
+		cfg:             cfg,
+		store:           store,
+		limiter:         limiter,
+		claudeClient:    claudeClient,
+		draftStore:      draftStore,
+		conversations:   conversations,
+		inlineCache:     inlineCache,
+		inlineDebouncer: bot.NewInlineDebouncer(inlineCache),
+		pool:            pool,
+		invoices:        invoice.NewSigner(cfg.InvoiceSecret, cfg.InvoiceTTL),
+		transcriber:     transcriber,
+		stopping:        stopping,
+	}
+
+	switch cfg.UpdateMode {
+	case config.UpdateModeWebhook:
+		runWebhook(telegramBot, cfg, d, stopping)
+	default:
+		runPolling(telegramBot, d, stopping)
+	}
+
+	// No new updates arrive past this point. Drain or cancel in-flight work
+	// before the deferred Close calls shut down storage and Redis.
+	log.Printf("Waiting up to %s for in-flight requests...", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		log.Printf("Worker pool shutdown: %v", err)
+	}
+	log.Println("Bot stopped")
+}
+
+// newTranscriber creates the configured speech-to-text backend, or nil if voice messages are disabled
+func newTranscriber(cfg *config.Config) stt.Transcriber {
+	switch cfg.STTBackend {
+	case config.STTBackendWhisper:
+		// Transcribing a long recording takes longer than the default client timeout
+		sttClient := &http.Client{Timeout: 2 * time.Minute}
+		log.Printf("Speech-to-text initialized: %s (%s)", cfg.STTAPIURL, cfg.STTModel)
+		return stt.NewWhisper(cfg.STTAPIURL, cfg.STTAPIKey, cfg.STTModel, sttClient)
+	case config.STTBackendFake:
+		log.Println("Speech-to-text initialized: fake backend")
+		return stt.NewFake("")
+	default:
+		log.Println("Speech-to-text disabled; voice messages will be declined")
+		return nil
+	}
+}
+
+// runPolling fetches updates with long polling until a stop signal arrives
+func runPolling(telegramBot *tgbotapi.BotAPI, d *dispatcher, stopping context.Context) {
+	// Long polling doesn't work while a webhook is set
+	if err := webhook.Delete(telegramBot); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	// Configure update parameters
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
@@ -63,46 +182,77 @@ func main() {
 	// Get updates channel
 	updates := telegramBot.GetUpdatesChan(u)
 
-	log.Println("Bot is running. Press Ctrl+C to stop.")
+	log.Println("Bot is running in polling mode. Press Ctrl+C to stop.")
 
 	// Process updates
-	for update := range updates {
-		if update.PreCheckoutQuery != nil {
-			go bot.HandlePreCheckout(telegramBot, update.PreCheckoutQuery)
-			continue
-		}
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.dispatch(update)
+		case <-stopping.Done():
+			log.Println("Received stop signal, stopping polling")
+			telegramBot.StopReceivingUpdates()
 
-		if update.Message == nil {
-			continue
+			// Queue updates that were already fetched and acknowledged, as far as the pool has room
+			for {
+				select {
+				case update, ok := <-updates:
+					if !ok {
+						return
+					}
+					d.dispatch(update)
+				default:
+					return
+				}
+			}
 		}
+	}
+}
 
-		if update.Message.SuccessfulPayment != nil {
-			go bot.HandleSuccessfulPayment(telegramBot, update.Message, store)
-			continue
-		}
+// runWebhook registers the webhook and serves updates until a stop signal arrives
+func runWebhook(telegramBot *tgbotapi.BotAPI, cfg *config.Config, d *dispatcher, stopping context.Context) {
+	webhookURL, err := url.Parse(cfg.WebhookURL)
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_URL: %v", err)
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
 
-		// Handle commands
-		if update.Message.IsCommand() {
-			switch update.Message.Command() {
-			case "start":
-				go bot.HandleStart(telegramBot, update.Message)
-			case "help":
-				go bot.HandleHelp(telegramBot, update.Message)
-			case "stats":
-				go bot.HandleStats(telegramBot, update.Message, limiter, store)
-			case "subscribe":
-				go bot.HandleSubscribe(telegramBot, update.Message, cfg)
-			default:
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID,
-					"Unknown command. Use /help to see available commands.")
-				telegramBot.Send(msg)
-			}
-			continue
-		}
+	server := webhook.New(cfg.WebhookListenAddr, path, cfg.WebhookSecret, d.dispatch)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	if err := webhook.Register(telegramBot, cfg.WebhookURL, cfg.WebhookSecret, 0); err != nil {
+		log.Fatalf("Failed to register webhook: %v", err)
+	}
+
+	log.Println("Bot is running in webhook mode. Press Ctrl+C to stop.")
+
+	select {
+	case <-stopping.Done():
+		log.Println("Received stop signal, shutting down webhook server")
+	case err := <-serverErr:
+		log.Printf("Webhook server stopped: %v", err)
+	}
 
-		// Handle text messages
-		if update.Message.Text != "" {
-			go bot.HandleTextMessage(telegramBot, update.Message, httpClient, cfg, store, limiter, claudeClient)
+	// Other replicas may still be serving the same webhook, so only a single instance deletes it
+	if cfg.WebhookDeleteOnShutdown {
+		if err := webhook.Delete(telegramBot); err != nil {
+			log.Printf("Error deleting webhook: %v", err)
 		}
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down webhook server: %v", err)
+	}
 }
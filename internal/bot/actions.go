@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// rewriteCallbackPrefix marks callback data produced by the buttons under a rewrite
+const rewriteCallbackPrefix = "rw:"
+
+// regenerateAction re-runs the draft without a modifier
+const regenerateAction = "regenerate"
+
+// rewriteKeyboard builds the action buttons attached to every rewrite
+func rewriteKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Regenerate", rewriteCallbackPrefix+regenerateAction),
+			tgbotapi.NewInlineKeyboardButtonData("✂️ Shorter", rewriteCallbackPrefix+string(claude.ModifierShorter)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎩 More formal", rewriteCallbackPrefix+string(claude.ModifierMoreFormal)),
+			tgbotapi.NewInlineKeyboardButtonData("🕊 Softer", rewriteCallbackPrefix+string(claude.ModifierSofter)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🌐 Original language", rewriteCallbackPrefix+string(claude.ModifierOriginalLanguage)),
+		),
+	)
+}
+
+// decodeKeyboard builds the action buttons attached to a decoded message. Tone
+// modifiers make no sense for a plain-language summary, so only these two remain.
+func decodeKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Regenerate", rewriteCallbackPrefix+regenerateAction),
+			tgbotapi.NewInlineKeyboardButtonData("✂️ Shorter", rewriteCallbackPrefix+string(claude.ModifierShorter)),
+		),
+	)
+}
+
+// handleRewriteCallback re-runs the draft behind a bot reply with the modifier of the pressed button
+func handleRewriteCallback(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	query *tgbotapi.CallbackQuery,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+) {
+	action := strings.TrimPrefix(query.Data, rewriteCallbackPrefix)
+	modifier := claude.Modifier(action)
+	if action == regenerateAction {
+		modifier = claude.ModifierNone
+	}
+	if !modifier.IsValid() || query.Message == nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, "This button is no longer supported."))
+		return
+	}
+
+	draft, err := draftStore.Get(ctx, query.Message.Chat.ID, query.Message.MessageID)
+	if err != nil {
+		log.Printf("Error loading draft: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Sorry, couldn't load the original message. Please try again."))
+		return
+	}
+	if draft == nil {
+		bot.Request(tgbotapi.NewCallbackWithAlert(query.ID, "The original message has expired. Please send it again."))
+		return
+	}
+	if draft.TelegramID != query.From.ID {
+		bot.Request(tgbotapi.NewCallback(query.ID, "Only the author of this draft can use these buttons."))
+		return
+	}
+
+	user, err := store.GetOrCreateUser(ctx, query.From.ID, query.From.UserName, query.From.FirstName, query.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Sorry, couldn't process your request. Please try again."))
+		return
+	}
+
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "Working on it...")); err != nil {
+		log.Printf("Error answering callback: %v", err)
+	}
+
+	// Replies to screenshots need the image again
+	var image *claude.Image
+	if draft.PhotoFileID != "" {
+		image, err = downloadImage(ctx, bot, httpClient, draft.PhotoFileID, 0, 0)
+		if err != nil {
+			log.Printf("Error downloading photo: %v", err)
+			msg := tgbotapi.NewMessage(query.Message.Chat.ID, "Sorry, couldn't load the original screenshot. Please send it again.")
+			bot.Send(msg)
+			return
+		}
+	}
+
+	// Replies to a quoted message need the quote again
+	var replyContext *claude.ReplyContext
+	if draft.ContextText != "" {
+		replyContext = &claude.ReplyContext{Author: draft.ContextAuthor, Text: draft.ContextText}
+	}
+
+	job := rewriteJob{
+		chatID: query.Message.Chat.ID,
+		from:   query.From,
+		user:   user,
+		request: claude.RewriteRequest{
+			Style:    draft.Style,
+			Mode:     claude.Mode(draft.Mode),
+			Text:     draft.Text,
+			Modifier: modifier,
+			Image:    image,
+			Context:  replyContext,
+		},
+		photoFileID: draft.PhotoFileID,
+	}
+	runRewrite(ctx, bot, cfg, store, limiter, claudeClient, draftStore, job)
+}
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// accountingTimeout bounds billing writes made after the request context may be gone
+const accountingTimeout = 5 * time.Second
+
+// detachedContext returns a context for billing and logging writes that must still
+// happen when the request itself was cancelled, e.g. to refund a reservation on shutdown
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), accountingTimeout)
+}
+
+// charge tracks tokens held for one request until it is settled or refunded
+type charge struct {
+	telegramID      int64
+	userID          int64 // internal users.id
+	location        *time.Location
+	estimatedTokens int
+	usePaidTokens   bool
+	reservation     *ratelimit.Reservation // rate limit hold; reserves daily tokens only when not billed to paid balances
+}
+
+// reserveTokens bills the request to the user's paid balances (subscription, then
+// top-ups) if they cover the estimate, otherwise reserves the estimate from the free
+// daily limit. The other rules of the rate limit policy apply to paying users too.
+// hold is how long a long-running job keeps its in-flight slot; 0 for a single rewrite.
+// Returns: (charge, denial, error). The charge is nil if the request was denied.
+func reserveTokens(
+	ctx context.Context,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	user *storage.User,
+	estimatedTokens int,
+	messageLength int,
+	hold time.Duration,
+) (*charge, *ratelimit.Denial, error) {
+	return reserve(ctx, store, limiter, user, ratelimit.Request{
+		Tokens:        estimatedTokens,
+		MessageLength: messageLength,
+		Hold:          hold,
+	})
+}
+
+// reservePart bills one part of a job, e.g. a document chunk, like reserveTokens. The job
+// must hold its own reservation for the in-flight slot, so only the daily token rule applies.
+func reservePart(
+	ctx context.Context,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	user *storage.User,
+	estimatedTokens int,
+) (*charge, *ratelimit.Denial, error) {
+	return reserve(ctx, store, limiter, user, ratelimit.Request{
+		Tokens:       estimatedTokens,
+		Continuation: true,
+	})
+}
+
+// reserve picks the balance that pays for req and reserves it with the limiter
+func reserve(
+	ctx context.Context,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	user *storage.User,
+	req ratelimit.Request,
+) (*charge, *ratelimit.Denial, error) {
+	c := &charge{
+		telegramID:      user.TelegramID,
+		userID:          user.ID,
+		location:        user.Location(),
+		estimatedTokens: req.Tokens,
+	}
+
+	// Check paid balances
+	if paid, err := store.PaidTokens(ctx, user.ID); err != nil {
+		log.Printf("Error reading balances: %v", err)
+	} else if paid > 0 && paid >= req.Tokens {
+		c.usePaidTokens = true
+	}
+
+	req.TelegramID = user.TelegramID
+	req.Location = c.location
+	if c.usePaidTokens {
+		req.Tokens = 0
+	}
+
+	// Check rate limits and reserve tokens
+	reservation, denial, err := limiter.CheckAndReserve(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if denial != nil {
+		return nil, denial, nil
+	}
+	c.reservation = reservation
+
+	return c, nil, nil
+}
+
+// refund releases the reservation of a request that failed
+func (c *charge) refund(ctx context.Context, limiter *ratelimit.Limiter) {
+	if _, err := limiter.Refund(ctx, c.reservation); err != nil {
+		log.Printf("Error refunding tokens: %v", err)
+	}
+}
+
+// settle bills the actual token usage of a successful request and counts it in the stats.
+// It returns false if the paid balances could no longer cover the request.
+func (c *charge) settle(ctx context.Context, store *storage.Storage, limiter *ratelimit.Limiter, actualTokens int) bool {
+	covered := true
+
+	// Replace the estimate with actual tokens; paying users only free their in-flight slot
+	dailyTokens := actualTokens
+	if c.usePaidTokens {
+		dailyTokens = 0
+		if shortfall, err := store.ConsumeTokens(ctx, c.userID, actualTokens); err != nil {
+			log.Printf("Error consuming paid tokens: %v", err)
+		} else if shortfall > 0 {
+			covered = false
+		}
+	}
+	if _, err := limiter.Settle(ctx, c.reservation, dailyTokens); err != nil {
+		log.Printf("Error adjusting token usage: %v", err)
+	}
+
+	// Increment request counter for overall stats
+	if err := limiter.IncrementRequests(ctx, c.telegramID, c.location); err != nil {
+		log.Printf("Error incrementing request count: %v", err)
+	}
+
+	return covered
+}
+
+// denialMessage tells the user which rate limit they hit and when it clears
+func denialMessage(denial *ratelimit.Denial) string {
+	switch denial.Rule {
+	case ratelimit.RuleMessageLength:
+		return fmt.Sprintf(
+			"✂️ Your message is too long (%d characters).\n\n"+
+				"Please keep it under %d characters or split it into several messages.",
+			denial.Current, denial.Limit)
+	case ratelimit.RuleConcurrent:
+		return fmt.Sprintf(
+			"⏳ You already have %d rewrites in progress.\n\n"+
+				"Please wait for them to finish before sending another message.",
+			denial.Current)
+	case ratelimit.RuleRequestsPerMinute:
+		seconds := int(denial.RetryAfter.Seconds()) + 1
+		return fmt.Sprintf(
+			"⏳ Slow down! You can send up to %d messages per minute.\n\n"+
+				"Try again in %ds.",
+			denial.Limit, seconds)
+	default:
+		hours := int(denial.RetryAfter.Hours())
+		minutes := int(denial.RetryAfter.Minutes()) % 60
+		return fmt.Sprintf(
+			"⚠️ Daily limit reached!\n\n"+
+				"You've used your daily allocation of %d tokens.\n"+
+				"Remaining: %d tokens\n\n"+
+				"Your limit will reset in %dh %dm\n"+
+				"Use /stats to check your usage or /subscribe for a bigger pool.",
+			denial.Limit, denial.Remaining, hours, minutes)
+	}
+}
+
+// inlineDenialText is the short version of denialMessage that fits an inline button
+func inlineDenialText(denial *ratelimit.Denial) string {
+	switch denial.Rule {
+	case ratelimit.RuleMessageLength:
+		return fmt.Sprintf("✂️ Too long — keep it under %d characters", denial.Limit)
+	case ratelimit.RuleConcurrent, ratelimit.RuleRequestsPerMinute:
+		return "⏳ Too many requests — try again in a moment"
+	default:
+		return "⚠️ Daily limit reached — tap to see options"
+	}
+}
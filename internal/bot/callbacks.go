@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/invoice"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// HandleCallbackQuery routes inline keyboard button presses by their data prefix
+func HandleCallbackQuery(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	query *tgbotapi.CallbackQuery,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	signer *invoice.Signer,
+) {
+	switch {
+	case strings.HasPrefix(query.Data, styleCallbackPrefix):
+		handleStyleCallback(ctx, bot, query, store)
+	case strings.HasPrefix(query.Data, planCallbackPrefix):
+		handlePlanCallback(ctx, bot, query, cfg, store, signer)
+	case strings.HasPrefix(query.Data, rewriteCallbackPrefix):
+		handleRewriteCallback(ctx, bot, query, httpClient, cfg, store, limiter, claudeClient, draftStore)
+	default:
+		log.Printf("Unknown callback data from user %d: %q", query.From.ID, query.Data)
+		if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+			log.Printf("Error answering callback: %v", err)
+		}
+	}
+}
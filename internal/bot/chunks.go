@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// telegramMessageLimit is the maximum length of a message, in UTF-16 code units
+	telegramMessageLimit = 4096
+	// partHeaderReserve leaves room for the "(2/3)" header of numbered parts
+	partHeaderReserve = 16
+	// maxMessageParts is the most parts sent as messages; longer text becomes a .txt file
+	maxMessageParts = 3
+	// longTextFileName is the name of the attachment used for very long text
+	longTextFileName = "rewrite.txt"
+)
+
+// textLength returns the length of text as Telegram counts it
+func textLength(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// prefixWithin returns the byte length of the longest prefix of text that fits in limit UTF-16 units
+func prefixWithin(text string, limit int) int {
+	n := 0
+	for i, r := range text {
+		n += utf16.RuneLen(r)
+		if n > limit {
+			return i
+		}
+	}
+	return len(text)
+}
+
+// splitMessage breaks text into parts of at most limit UTF-16 units. It prefers
+// paragraph breaks, then line breaks, then sentence ends, then spaces, and only
+// cuts inside a word when nothing else fits. Cuts never split a UTF-8 sequence.
+func splitMessage(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+
+	var parts []string
+	for textLength(text) > limit {
+		window := text[:prefixWithin(text, limit)]
+
+		cut := splitPoint(window)
+		if cut <= 0 {
+			cut = len(window)
+			if cut == 0 {
+				// A single character wider than the limit; take it anyway to make progress
+				_, cut = utf8.DecodeRuneInString(text)
+			}
+		}
+
+		if part := strings.TrimSpace(text[:cut]); part != "" {
+			parts = append(parts, part)
+		}
+		text = strings.TrimSpace(text[cut:])
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+
+	return parts
+}
+
+// splitPoint finds the best byte offset to end a part within window.
+// Breaks in the first half of the window are ignored so parts don't get tiny.
+func splitPoint(window string) int {
+	minCut := len(window) / 2
+
+	if i := strings.LastIndex(window, "\n\n"); i > minCut {
+		return i
+	}
+	if i := strings.LastIndex(window, "\n"); i > minCut {
+		return i
+	}
+	if i := lastSentenceEnd(window); i > minCut {
+		return i
+	}
+	if i := strings.LastIndexFunc(window, unicode.IsSpace); i > minCut {
+		return i
+	}
+	return -1
+}
+
+// lastSentenceEnd returns the offset just after the last sentence terminator
+// that is followed by whitespace, or -1
+func lastSentenceEnd(text string) int {
+	end := -1
+	afterTerminator := false
+	for i, r := range text {
+		if afterTerminator && unicode.IsSpace(r) {
+			end = i
+		}
+		afterTerminator = r == '.' || r == '!' || r == '?' || r == '…'
+	}
+	return end
+}
+
+// numberParts adds a "(1/3)" header to every part if there is more than one
+func numberParts(parts []string) []string {
+	if len(parts) < 2 {
+		return parts
+	}
+	numbered := make([]string, len(parts))
+	for i, part := range parts {
+		numbered[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), part)
+	}
+	return numbered
+}
+
+// sendLongText sends text as one message, as numbered parts, or as a .txt attachment
+// when it would need more than maxMessageParts messages. The keyboard goes on the
+// last message, whose ID is returned; on error 0 is returned, as the keyboard never went out.
+// If reply is set, its placeholder becomes the first part.
+func sendLongText(
+	bot *tgbotapi.BotAPI,
+	chatID int64,
+	replyTo int,
+	reply *streamingReply,
+	text string,
+	keyboard *tgbotapi.InlineKeyboardMarkup,
+) (int, error) {
+	parts := splitMessage(text, telegramMessageLimit-partHeaderReserve)
+	if len(parts) == 0 {
+		parts = []string{text}
+	}
+
+	if len(parts) > maxMessageParts {
+		if reply != nil {
+			reply.Finish("📄 The result is long, so I'm sending it as a file.", nil)
+		}
+		return sendTextDocument(bot, chatID, replyTo, longTextFileName, text, keyboard)
+	}
+
+	parts = numberParts(parts)
+
+	messageID := 0
+	for i, part := range parts {
+		var partKeyboard *tgbotapi.InlineKeyboardMarkup
+		if i == len(parts)-1 {
+			partKeyboard = keyboard
+		}
+
+		if i == 0 && reply != nil {
+			if err := reply.Finish(part, partKeyboard); err != nil {
+				return 0, err
+			}
+			messageID = reply.messageID
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(chatID, part)
+		if i == 0 {
+			msg.ReplyToMessageID = replyTo
+		}
+		if partKeyboard != nil {
+			msg.ReplyMarkup = *partKeyboard
+		}
+		sent, err := bot.Send(msg)
+		if err != nil {
+			return 0, fmt.Errorf("failed to send part %d/%d: %w", i+1, len(parts), err)
+		}
+		messageID = sent.MessageID
+	}
+
+	return messageID, nil
+}
+
+// sendTextDocument sends text as a UTF-8 .txt attachment
+func sendTextDocument(
+	bot *tgbotapi.BotAPI,
+	chatID int64,
+	replyTo int,
+	name string,
+	text string,
+	keyboard *tgbotapi.InlineKeyboardMarkup,
+) (int, error) {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: []byte(text)})
+	doc.Caption = fmt.Sprintf("📄 %d characters — too long for a message", utf8.RuneCountInString(text))
+	doc.ReplyToMessageID = replyTo
+	if keyboard != nil {
+		doc.ReplyMarkup = *keyboard
+	}
+
+	sent, err := bot.Send(doc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send document: %w", err)
+	}
+	return sent.MessageID, nil
+}
@@ -0,0 +1,191 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTextLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"привет", 6},
+		{"👋", 2},
+		{"ok 👍🏽", 7},
+		{"€𝄞", 3},
+	}
+	for _, tt := range tests {
+		if got := textLength(tt.text); got != tt.want {
+			t.Errorf("textLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixWithin(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  int // bytes
+	}{
+		{"hello", 5, 5},
+		{"hello", 3, 3},
+		{"привет", 2, 4},
+		{"a👋b", 2, 1}, // the emoji needs two units and only one is left
+		{"a👋b", 3, 5},
+		{"👋", 1, 0},
+	}
+	for _, tt := range tests {
+		if got := prefixWithin(tt.text, tt.limit); got != tt.want {
+			t.Errorf("prefixWithin(%q, %d) = %d, want %d", tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string // nil to only check the invariants
+	}{
+		{
+			name:  "exactly at the limit",
+			text:  strings.Repeat("a", 10),
+			limit: 10,
+			want:  []string{strings.Repeat("a", 10)},
+		},
+		{
+			name:  "one character over the limit",
+			text:  strings.Repeat("a", 11),
+			limit: 10,
+			want:  []string{strings.Repeat("a", 10), "a"},
+		},
+		{
+			name:  "Cyrillic exactly at the limit counts characters, not bytes",
+			text:  strings.Repeat("ж", 10),
+			limit: 10,
+			want:  []string{strings.Repeat("ж", 10)},
+		},
+		{
+			name:  "Cyrillic one over the limit",
+			text:  strings.Repeat("ж", 11),
+			limit: 10,
+			want:  []string{strings.Repeat("ж", 10), "ж"},
+		},
+		{
+			name:  "emoji count as two units",
+			text:  strings.Repeat("😀", 6),
+			limit: 10,
+			want:  []string{strings.Repeat("😀", 5), "😀"},
+		},
+		{
+			name:  "emoji straddling the limit moves to the next part",
+			text:  "abc😀def",
+			limit: 4,
+			want:  []string{"abc", "😀de", "f"},
+		},
+		{
+			name:  "prefers paragraph breaks",
+			text:  "First paragraph here.\n\nSecond one.",
+			limit: 30,
+			want:  []string{"First paragraph here.", "Second one."},
+		},
+		{
+			name:  "then sentence ends",
+			text:  "Один. Два три четыре. Пять",
+			limit: 24,
+			want:  []string{"Один. Два три четыре.", "Пять"},
+		},
+		{
+			name:  "then spaces",
+			text:  "alpha beta gamma delta",
+			limit: 12,
+			want:  []string{"alpha beta", "gamma delta"},
+		},
+		{
+			name:  "no whitespace to break on",
+			text:  strings.Repeat("длинноеслово", 3),
+			limit: 15,
+		},
+		{
+			name:  "surrogate pairs without whitespace",
+			text:  strings.Repeat("🚀", 25),
+			limit: 9,
+		},
+		{
+			name:  "limit narrower than one character",
+			text:  "😀😀",
+			limit: 1,
+			want:  []string{"😀", "😀"},
+		},
+		{
+			name:  "surrounding whitespace is dropped",
+			text:  "  \n text \n ",
+			limit: 10,
+			want:  []string{"text"},
+		},
+		{
+			name:  "empty",
+			text:  "   ",
+			limit: 10,
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitMessage(tt.text, tt.limit)
+
+			for i, part := range parts {
+				if !utf8.ValidString(part) {
+					t.Errorf("part %d is not valid UTF-8: %q", i, part)
+				}
+				if n := textLength(part); n > tt.limit && utf8.RuneCountInString(part) > 1 {
+					t.Errorf("part %d is %d units long, over the limit of %d", i, n, tt.limit)
+				}
+			}
+			if tt.want == nil {
+				if joined := strings.Join(parts, ""); joined != tt.text {
+					t.Errorf("parts %q don't add up to the text", parts)
+				}
+				return
+			}
+			if len(parts) != len(tt.want) {
+				t.Fatalf("splitMessage = %q, want %q", parts, tt.want)
+			}
+			for i := range parts {
+				if parts[i] != tt.want[i] {
+					t.Errorf("part %d = %q, want %q", i, parts[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNumberedPartsStayWithinTelegramLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"Latin words", strings.Repeat("word ", 2500)},
+		{"Cyrillic without whitespace", strings.Repeat("я", 3*telegramMessageLimit)},
+		{"emoji without whitespace", strings.Repeat("🎉", 2*telegramMessageLimit)},
+		{"full-size paragraphs", strings.Repeat(strings.Repeat("x", telegramMessageLimit-partHeaderReserve)+"\n\n", 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := numberParts(splitMessage(tt.text, telegramMessageLimit-partHeaderReserve))
+			if len(parts) < 2 {
+				t.Fatalf("got %d parts, want the text split", len(parts))
+			}
+			for i, part := range parts {
+				if n := textLength(part); n > telegramMessageLimit {
+					t.Errorf("numbered part %d is %d units long, over Telegram's %d", i+1, n, telegramMessageLimit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// HandleDecode handles the /decode command. "/decode <text>", or /decode sent in reply
+// to a message, decodes that text once; a bare /decode toggles reverse mode, in which
+// every plain message is decoded instead of rewritten.
+func HandleDecode(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+) {
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	text := strings.TrimSpace(message.CommandArguments())
+	replyTo := 0
+	if text == "" && message.ReplyToMessage != nil {
+		text = strings.TrimSpace(messageText(message.ReplyToMessage))
+		replyTo = message.ReplyToMessage.MessageID
+	}
+	if text != "" {
+		runDecode(ctx, bot, cfg, store, limiter, claudeClient, draftStore, message, user, text, replyTo)
+		return
+	}
+
+	enabled := !user.DecodeMode
+	if err := store.SetUserDecodeMode(ctx, user.TelegramID, enabled); err != nil {
+		log.Printf("Error setting decode mode: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't switch modes. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	reply := "🔍 Decode mode on. Send or forward any corporate message and I'll tell you what it actually means. " +
+		"Send /decode again to go back to rewriting."
+	if !enabled {
+		reply = "✍️ Decode mode off. Your messages are rewritten into corporate speak again."
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	bot.Send(msg)
+}
+
+// runDecode translates text from corporate speak into plain language through the
+// same charging, logging and delivery path as rewrites
+func runDecode(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	message *tgbotapi.Message,
+	user *storage.User,
+	text string,
+	replyTo int,
+) {
+	job := rewriteJob{
+		chatID:  message.Chat.ID,
+		replyTo: replyTo,
+		from:    message.From,
+		user:    user,
+		request: claude.RewriteRequest{
+			Style: user.Style,
+			Text:  text,
+			Mode:  claude.ModeDecode,
+		},
+	}
+	runRewrite(ctx, bot, cfg, store, limiter, claudeClient, draftStore, job)
+}
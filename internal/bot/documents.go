@@ -0,0 +1,394 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/document"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+const (
+	// documentProgressInterval throttles edits of the progress message
+	documentProgressInterval = 2 * time.Second
+	// documentChunkTimeout bounds one rewrite call, including retries
+	documentChunkTimeout = 60 * time.Second
+)
+
+// documentChunk is one rewrite call: a paragraph, or a piece of a long one
+type documentChunk struct {
+	block int // index into Document.Blocks
+	text  string
+}
+
+// downloadFile fetches a file the user sent, refusing anything larger than maxSize bytes
+func downloadFile(ctx context.Context, bot *tgbotapi.BotAPI, httpClient *http.Client, fileID string, maxSize int) ([]byte, error) {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxSize)
+	}
+
+	return data, nil
+}
+
+// documentChunks splits the rewritable blocks into chunks of at most limit characters
+func documentChunks(doc *document.Document, limit int) []documentChunk {
+	var chunks []documentChunk
+	for _, i := range doc.RewritableBlocks() {
+		for _, piece := range splitMessage(doc.Blocks[i].Text, limit) {
+			chunks = append(chunks, documentChunk{block: i, text: piece})
+		}
+	}
+	return chunks
+}
+
+// polishedFileName names the returned file after the upload, keeping its extension
+func polishedFileName(name string, format document.Format) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base = "document"
+	}
+	if ext == "" {
+		ext = "." + string(format)
+	}
+	return base + "_polished" + ext
+}
+
+// HandleDocument rewrites an uploaded .txt, .md or .docx file paragraph by paragraph and
+// sends back a file in the same format. The document is admitted as one request and every
+// paragraph is billed as it is rewritten; if the limit is hit or a call fails, the paragraphs
+// done so far are still returned.
+func HandleDocument(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+) {
+	upload := message.Document
+
+	format, err := document.DetectFormat(upload.FileName, upload.MimeType)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "📄 I can rewrite .txt, .md and .docx files. Please send one of those.")
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	if upload.FileSize > cfg.MaxDocumentSize {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"📄 This file is too large. Please send documents up to %d KB.", cfg.MaxDocumentSize/1024))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	data, err := downloadFile(ctx, bot, httpClient, upload.FileID, cfg.MaxDocumentSize)
+	if err != nil {
+		log.Printf("Error downloading document: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't download your file. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	doc, err := document.Parse(format, data)
+	if err != nil {
+		log.Printf("Error parsing document %q: %v", upload.FileName, err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "📄 I couldn't read this file. Please check that it isn't damaged or password-protected.")
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	chunkLimit := cfg.MaxMessageLength
+	if chunkLimit <= 0 {
+		chunkLimit = config.DefaultMaxMessageLength
+	}
+	chunks := documentChunks(doc, chunkLimit)
+	if len(chunks) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "📄 I couldn't find any text to rewrite in this file.")
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+	if len(chunks) > cfg.MaxDocumentChunks {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"📄 This document has %d paragraphs to rewrite; the maximum is %d. Please split it into smaller files.",
+			len(chunks), cfg.MaxDocumentChunks))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	// Admit the document as one request: its reservation holds the in-flight slot until every
+	// chunk has had its full time, while each chunk reserves only its own tokens.
+	// Chunks are already cut to the message length limit.
+	documentTimeout := time.Duration(len(chunks)) * documentChunkTimeout
+	admission, denial, err := reserveTokens(ctx, store, limiter, user, 0, 0, documentTimeout+accountingTimeout)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+	if denial != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, denialMessage(denial))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+	defer func() {
+		// The admission carries no tokens; releasing it frees the slot without counting a request
+		releaseCtx, cancelRelease := detachedContext(ctx)
+		defer cancelRelease()
+		admission.refund(releaseCtx, limiter)
+	}()
+
+	// Keep the job within its slot, so a /timezone change can't slip in before settlement
+	docCtx, cancel := context.WithTimeout(ctx, documentTimeout)
+	defer cancel()
+
+	progress := newDocumentProgress(bot, message.Chat.ID, message.MessageID, upload.FileName, len(chunks))
+
+	// Rewritten pieces per block; a block is replaced once all of its pieces are done
+	rewritten := make(map[int][]string)
+	pieces := make(map[int]int)
+	for _, chunk := range chunks {
+		pieces[chunk.block]++
+	}
+
+	done, totalTokens := 0, 0
+	stopReason := ""
+	for _, chunk := range chunks {
+		if docCtx.Err() != nil {
+			stopReason = userErrorMessage(docCtx.Err())
+			break
+		}
+
+		result, reason := rewriteDocumentChunk(docCtx, store, limiter, claudeClient, user, chunk.text)
+		if reason != "" {
+			stopReason = reason
+			break
+		}
+
+		rewritten[chunk.block] = append(rewritten[chunk.block], result.text)
+		if len(rewritten[chunk.block]) == pieces[chunk.block] {
+			doc.Blocks[chunk.block].Text = strings.Join(rewritten[chunk.block], " ")
+		}
+		done++
+		totalTokens += result.used
+		progress.Update(done)
+	}
+
+	log.Printf("User %d (%s) rewrote %d/%d document parts using %d tokens", user.TelegramID, message.From.UserName, done, len(chunks), totalTokens)
+
+	if done == 0 {
+		progress.Finish(stopReason)
+		return
+	}
+
+	output, err := doc.Render()
+	if err != nil {
+		log.Printf("Error rendering document: %v", err)
+		progress.Finish("Sorry, couldn't build the rewritten file. Please try again.")
+		return
+	}
+
+	caption := fmt.Sprintf("✅ Rewrote %d paragraphs (%d tokens).", done, totalTokens)
+	if done < len(chunks) {
+		caption = fmt.Sprintf("⚠️ Rewrote %d of %d paragraphs (%d tokens); the rest is unchanged.", done, len(chunks), totalTokens)
+		progress.Finish(stopReason)
+	} else {
+		progress.Finish(fmt.Sprintf("📄 %s: done.", upload.FileName))
+	}
+
+	reply := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{Name: polishedFileName(upload.FileName, format), Bytes: output})
+	reply.Caption = caption
+	reply.ReplyToMessageID = message.MessageID
+	if _, err := bot.Send(reply); err != nil {
+		log.Printf("Error sending rewritten document: %v", err)
+	}
+}
+
+// chunkResult is the outcome of one successful chunk rewrite
+type chunkResult struct {
+	text string
+	used int
+}
+
+// documentRequest builds the rewrite request for one chunk
+func documentRequest(user *storage.User, text string) claude.RewriteRequest {
+	return claude.RewriteRequest{Style: user.Style, Text: text, DocumentPart: true}
+}
+
+// rewriteDocumentChunk rewrites and bills one chunk under the document's admission.
+// On failure it returns a message explaining why processing stopped.
+func rewriteDocumentChunk(
+	ctx context.Context,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	user *storage.User,
+	text string,
+) (chunkResult, string) {
+	request := documentRequest(user, text)
+
+	estimate := claudeClient.EstimateTokens(ctx, request)
+	estimatedTokens := estimate.Total()
+
+	charge, denial, err := reservePart(ctx, store, limiter, user, estimatedTokens)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		return chunkResult{}, "Sorry, couldn't process your request. Please try again."
+	}
+	if denial != nil {
+		return chunkResult{}, denialMessage(denial)
+	}
+
+	apiCtx, cancel := context.WithTimeout(ctx, documentChunkTimeout)
+	defer cancel()
+
+	result, err := claudeClient.RewriteToCorporate(apiCtx, request)
+	actualTokens := result.InputTokens + result.OutputTokens
+
+	// Billing must complete even if the request was cancelled by a shutdown
+	billCtx, cancelBill := detachedContext(ctx)
+	defer cancelBill()
+
+	usageLog := &storage.UsageLog{
+		UserID:          user.ID,
+		InputTokens:     result.InputTokens,
+		OutputTokens:    result.OutputTokens,
+		TotalTokens:     actualTokens,
+		MessagePreview:  truncateString(text, 500),
+		Model:           result.Model,
+		Success:         err == nil,
+		EstimatedTokens: estimatedTokens,
+		EstimateSource:  estimate.Source,
+		RequestType:     storage.RequestTypeDocument,
+	}
+
+	if err != nil {
+		log.Printf("Error calling Claude API for document: %v", err)
+		charge.refund(billCtx, limiter)
+		if logErr := store.LogUsage(billCtx, usageLog); logErr != nil {
+			log.Printf("Error logging failed usage: %v", logErr)
+		}
+		return chunkResult{}, userErrorMessage(err)
+	}
+
+	if !charge.settle(billCtx, store, limiter, actualTokens) {
+		log.Printf("Paid tokens of user %d no longer cover document chunks", user.TelegramID)
+	}
+
+	usageLog.ResponsePreview = truncateString(result.Text, 500)
+	if err := store.LogUsage(billCtx, usageLog); err != nil {
+		log.Printf("Error logging usage: %v", err)
+	}
+
+	return chunkResult{text: strings.TrimSpace(result.Text), used: actualTokens}, ""
+}
+
+// documentProgress keeps a status message up to date while a document is processed
+type documentProgress struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int // 0 if the status message couldn't be sent
+	name      string
+	total     int
+	lastEdit  time.Time
+}
+
+// newDocumentProgress sends the initial status message
+func newDocumentProgress(bot *tgbotapi.BotAPI, chatID int64, replyTo int, name string, total int) *documentProgress {
+	p := &documentProgress{bot: bot, chatID: chatID, name: name, total: total, lastEdit: time.Now()}
+
+	msg := tgbotapi.NewMessage(chatID, p.text(0))
+	msg.ReplyToMessageID = replyTo
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("Error sending progress message: %v", err)
+		return p
+	}
+	p.messageID = sent.MessageID
+	return p
+}
+
+// text renders the status line
+func (p *documentProgress) text(done int) string {
+	return fmt.Sprintf("📄 Rewriting %s: %d/%d paragraphs...", p.name, done, p.total)
+}
+
+// Update shows the number of finished chunks, at most every documentProgressInterval
+func (p *documentProgress) Update(done int) {
+	if p.messageID == 0 || time.Since(p.lastEdit) < documentProgressInterval {
+		return
+	}
+	p.edit(p.text(done))
+}
+
+// Finish replaces the status with a final message
+func (p *documentProgress) Finish(text string) {
+	if p.messageID == 0 {
+		if _, err := p.bot.Send(tgbotapi.NewMessage(p.chatID, text)); err != nil {
+			log.Printf("Error sending document status: %v", err)
+		}
+		return
+	}
+	p.edit(text)
+}
+
+// edit changes the status message
+func (p *documentProgress) edit(text string) {
+	p.lastEdit = time.Now()
+	edit := tgbotapi.NewEditMessageText(p.chatID, p.messageID, text)
+	if _, err := p.bot.Send(edit); err != nil {
+		log.Printf("Error updating progress message: %v", err)
+	}
+}
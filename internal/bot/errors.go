@@ -0,0 +1,28 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"corp-bullshifter/internal/claude"
+)
+
+// userErrorMessage picks the reply shown to the user for a failed rewrite
+func userErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "🔄 The bot is restarting. Please send your message again in a moment."
+	case errors.Is(err, claude.ErrRateLimited), errors.Is(err, claude.ErrOverloaded):
+		return "⏳ Claude is overloaded right now. Please try again in a minute."
+	case errors.Is(err, claude.ErrInvalidRequest):
+		return "I couldn't process this message. Try shortening or rephrasing it."
+	case errors.Is(err, claude.ErrAuth):
+		log.Printf("ALERT: Claude API rejected our credentials: %v", err)
+		return "The bot is temporarily unavailable. The admins have been notified."
+	case errors.Is(err, claude.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "⌛ The request took too long. Please try again."
+	default:
+		return "Sorry, I couldn't process your request right now. Please try again later."
+	}
+}
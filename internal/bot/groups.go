@@ -0,0 +1,397 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// groupCommands are the commands admins can allow or forbid in a group
+var groupCommands = []string{"polish", "help", "stats", "style"}
+
+// stripMention removes the mentions of the bot from text, found through the message's
+// mention entities. Returns the remaining text and whether the bot was mentioned.
+func stripMention(text string, entities []tgbotapi.MessageEntity, username string) (string, bool) {
+	if username == "" {
+		return text, false
+	}
+
+	// Entity offsets are in UTF-16 code units
+	units := utf16.Encode([]rune(text))
+	var rest []uint16
+	last, mentioned := 0, false
+	for _, entity := range entities {
+		end := entity.Offset + entity.Length
+		if entity.Type != "mention" || entity.Offset < last || end > len(units) {
+			continue
+		}
+		if !strings.EqualFold(string(utf16.Decode(units[entity.Offset:end])), "@"+username) {
+			continue
+		}
+		rest = append(rest, units[last:entity.Offset]...)
+		last = end
+		mentioned = true
+	}
+	if !mentioned {
+		return text, false
+	}
+	rest = append(rest, units[last:]...)
+	return string(utf16.Decode(rest)), true
+}
+
+// IsGroupChat reports whether a chat is a group or supergroup
+func IsGroupChat(chat *tgbotapi.Chat) bool {
+	return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// HandleGroupMessage handles every message in a group chat. Unlike private chats,
+// the bot only acts when mentioned, on /polish in reply to a message, or on the
+// group's opt-in trigger word. Usage is billed to the member who invoked the bot.
+func HandleGroupMessage(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+) {
+	if message.From == nil || message.From.IsBot {
+		return
+	}
+
+	settings, err := store.GetChatSettings(ctx, message.Chat.ID)
+	if err != nil {
+		log.Printf("Error loading chat settings: %v", err)
+		return
+	}
+
+	if message.IsCommand() {
+		// Ignore commands addressed to other bots in the group
+		if at := strings.Index(message.CommandWithAt(), "@"); at >= 0 &&
+			!strings.EqualFold(message.CommandWithAt()[at+1:], bot.Self.UserName) {
+			return
+		}
+
+		command := message.Command()
+		if command == "groupsettings" {
+			handleGroupSettings(ctx, bot, message, store, settings)
+			return
+		}
+		if !settings.Enabled || !settings.AllowsCommand(command) {
+			return
+		}
+
+		switch command {
+		case "polish":
+			handlePolish(ctx, bot, message, cfg, store, limiter, claudeClient, draftStore, settings)
+		case "help":
+			HandleHelp(bot, message)
+		case "stats":
+			HandleStats(ctx, bot, message, limiter, store)
+		case "style":
+			HandleStyle(ctx, bot, message, store)
+		}
+		return
+	}
+
+	if !settings.Enabled {
+		return
+	}
+
+	text, ok := groupTriggerText(bot, message, settings)
+	if !ok {
+		return
+	}
+	if text == "" {
+		reply := tgbotapi.NewMessage(message.Chat.ID, "Mention me with a draft, or reply to a message with /polish.")
+		reply.ReplyToMessageID = message.MessageID
+		bot.Send(reply)
+		return
+	}
+
+	runGroupRewrite(ctx, bot, message, cfg, store, limiter, claudeClient, draftStore, settings, text)
+}
+
+// handlePolish rewrites the message the /polish command replies to
+func handlePolish(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	settings *storage.ChatSettings,
+) {
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" && message.ReplyToMessage != nil {
+		text = strings.TrimSpace(message.ReplyToMessage.Text)
+	}
+	if text == "" {
+		reply := tgbotapi.NewMessage(message.Chat.ID, "Reply to a text message with /polish to rewrite it.")
+		reply.ReplyToMessageID = message.MessageID
+		bot.Send(reply)
+		return
+	}
+
+	runGroupRewrite(ctx, bot, message, cfg, store, limiter, claudeClient, draftStore, settings, text)
+}
+
+// runGroupRewrite runs a rewrite on behalf of the member who sent message
+func runGroupRewrite(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	settings *storage.ChatSettings,
+	text string,
+) {
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		return
+	}
+
+	style := user.Style
+	if settings.DefaultStyle != "" {
+		style = settings.DefaultStyle
+	}
+
+	job := rewriteJob{
+		chatID:  message.Chat.ID,
+		replyTo: message.MessageID,
+		from:    message.From,
+		user:    user,
+		request: claude.RewriteRequest{
+			Style: style,
+			Text:  text,
+		},
+	}
+	runRewrite(ctx, bot, cfg, store, limiter, claudeClient, draftStore, job)
+}
+
+// groupTriggerText decides whether a plain group message is addressed to the bot
+// and extracts the draft to rewrite. An empty draft falls back to the replied-to message.
+// Returns: (draft text, triggered)
+func groupTriggerText(bot *tgbotapi.BotAPI, message *tgbotapi.Message, settings *storage.ChatSettings) (string, bool) {
+	text := message.Text
+	triggered := false
+
+	if rest, ok := stripMention(text, message.Entities, bot.Self.UserName); ok {
+		text = rest
+		triggered = true
+	} else if rest, ok := cutTriggerWord(text, settings.TriggerWord); ok {
+		text = rest
+		triggered = true
+	}
+
+	if !triggered {
+		return "", false
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" && message.ReplyToMessage != nil {
+		text = strings.TrimSpace(message.ReplyToMessage.Text)
+	}
+
+	return text, true
+}
+
+// cutTriggerWord strips a leading trigger word (case-insensitive, optionally followed by ':')
+func cutTriggerWord(text, trigger string) (string, bool) {
+	if trigger == "" {
+		return "", false
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) < len(trigger) || !strings.EqualFold(trimmed[:len(trigger)], trigger) {
+		return "", false
+	}
+
+	rest := trimmed[len(trigger):]
+	if rest != "" && !strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, "\n") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(rest), ":"), true
+}
+
+// isChatAdmin reports whether the user administers the chat
+func isChatAdmin(bot *tgbotapi.BotAPI, chatID, userID int64) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		log.Printf("Error checking chat admin: %v", err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// handleGroupSettings lets group admins view and change the group's settings:
+//
+//	/groupsettings
+//	/groupsettings on|off
+//	/groupsettings style <style_id|none>
+//	/groupsettings trigger <word|off>
+//	/groupsettings commands polish,help,stats,style
+func handleGroupSettings(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, store *storage.Storage, settings *storage.ChatSettings) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyToMessageID = message.MessageID
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("Error sending group settings message: %v", err)
+		}
+	}
+
+	if !isChatAdmin(bot, message.Chat.ID, message.From.ID) {
+		reply("Only group admins can change my settings.")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		reply(groupSettingsText(settings))
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		settings.Enabled = true
+	case "off":
+		settings.Enabled = false
+	case "style":
+		if len(args) < 2 {
+			reply("Usage: /groupsettings style <style_id|none>")
+			return
+		}
+		if strings.EqualFold(args[1], "none") {
+			settings.DefaultStyle = ""
+		} else if style, ok := claude.FindStyle(args[1]); ok {
+			settings.DefaultStyle = style.ID
+		} else {
+			reply("Unknown style. Available: " + styleIDs())
+			return
+		}
+	case "trigger":
+		if len(args) < 2 {
+			reply("Usage: /groupsettings trigger <word|off>")
+			return
+		}
+		if strings.EqualFold(args[1], "off") {
+			settings.TriggerWord = ""
+		} else {
+			settings.TriggerWord = args[1]
+		}
+	case "commands":
+		if len(args) < 2 {
+			reply("Usage: /groupsettings commands " + strings.Join(groupCommands, ","))
+			return
+		}
+		allowed, unknown := parseGroupCommands(args[1])
+		if unknown != "" {
+			reply(fmt.Sprintf("Unknown command %q. Available: %s", unknown, strings.Join(groupCommands, ",")))
+			return
+		}
+		settings.AllowedCommands = allowed
+	default:
+		reply(groupSettingsText(settings))
+		return
+	}
+
+	if err := store.SaveChatSettings(ctx, settings); err != nil {
+		log.Printf("Error saving chat settings: %v", err)
+		reply("Sorry, couldn't save the settings. Please try again.")
+		return
+	}
+
+	reply("✅ Saved.\n\n" + groupSettingsText(settings))
+}
+
+// parseGroupCommands parses a comma-separated command list, accepting "none" for an empty list.
+// Returns: (allowed commands, first unknown command or "")
+func parseGroupCommands(raw string) ([]string, string) {
+	allowed := []string{}
+	if strings.EqualFold(raw, "none") {
+		return allowed, ""
+	}
+
+	for _, command := range strings.Split(raw, ",") {
+		command = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(command)), "/")
+		if command == "" {
+			continue
+		}
+		known := false
+		for _, groupCommand := range groupCommands {
+			if command == groupCommand {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, command
+		}
+		allowed = append(allowed, command)
+	}
+
+	return allowed, ""
+}
+
+// groupSettingsText renders the group's settings and how to change them
+func groupSettingsText(settings *storage.ChatSettings) string {
+	status := "on"
+	if !settings.Enabled {
+		status = "off"
+	}
+	style := "each member's own /style"
+	if settings.DefaultStyle != "" {
+		style = styleName(settings.DefaultStyle)
+	}
+	trigger := "off"
+	if settings.TriggerWord != "" {
+		trigger = settings.TriggerWord
+	}
+	commands := "none"
+	if len(settings.AllowedCommands) > 0 {
+		commands = strings.Join(settings.AllowedCommands, ", ")
+	}
+
+	return fmt.Sprintf(
+		"⚙️ Group settings\n\n"+
+			"Bot: %s\n"+
+			"Style: %s\n"+
+			"Trigger word: %s\n"+
+			"Allowed commands: %s\n\n"+
+			"Change with:\n"+
+			"/groupsettings on|off\n"+
+			"/groupsettings style <style_id|none>\n"+
+			"/groupsettings trigger <word|off>\n"+
+			"/groupsettings commands %s",
+		status, style, trigger, commands, strings.Join(groupCommands, ","))
+}
+
+// styleIDs lists the IDs of all styles, for usage hints
+func styleIDs() string {
+	ids := make([]string, 0, len(claude.Styles))
+	for _, style := range claude.Styles {
+		ids = append(ids, style.ID)
+	}
+	return strings.Join(ids, ", ")
+}
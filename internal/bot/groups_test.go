@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestStripMention(t *testing.T) {
+	mention := func(offset, length int) tgbotapi.MessageEntity {
+		return tgbotapi.MessageEntity{Type: "mention", Offset: offset, Length: length}
+	}
+
+	tests := []struct {
+		name          string
+		text          string
+		entities      []tgbotapi.MessageEntity
+		wantText      string
+		wantMentioned bool
+	}{
+		{"leading mention", "@CorpBot fix this", []tgbotapi.MessageEntity{mention(0, 8)}, " fix this", true},
+		{"case-insensitive", "@corpbot fix this", []tgbotapi.MessageEntity{mention(0, 8)}, " fix this", true},
+		{"after emoji and Cyrillic", "🙏 привет @CorpBot", []tgbotapi.MessageEntity{mention(10, 8)}, "🙏 привет ", true},
+		{"another bot", "@OtherBot fix this", []tgbotapi.MessageEntity{mention(0, 9)}, "@OtherBot fix this", false},
+		{"longer username with the same prefix", "@CorpBotX hi", []tgbotapi.MessageEntity{mention(0, 9)}, "@CorpBotX hi", false},
+		{"plain text without an entity", "mail me at x@CorpBot", nil, "mail me at x@CorpBot", false},
+		{"entity past the end", "@CorpBot", []tgbotapi.MessageEntity{mention(0, 20)}, "@CorpBot", false},
+		{
+			name:          "two mentions",
+			text:          "@CorpBot and @CorpBot",
+			entities:      []tgbotapi.MessageEntity{mention(0, 8), {Type: "bold", Offset: 9, Length: 3}, mention(13, 8)},
+			wantText:      " and ",
+			wantMentioned: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, mentioned := stripMention(tt.text, tt.entities, "CorpBot")
+			if text != tt.wantText || mentioned != tt.wantMentioned {
+				t.Errorf("stripMention(%q) = %q, %v; want %q, %v", tt.text, text, mentioned, tt.wantText, tt.wantMentioned)
+			}
+		})
+	}
+
+	if _, mentioned := stripMention("@CorpBot hi", []tgbotapi.MessageEntity{mention(0, 8)}, ""); mentioned {
+		t.Error("stripMention matched a bot without a username")
+	}
+}
@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         string
+		maxLength int
+		want      string
+	}{
+		{"short enough", "hello", 5, "hello"},
+		{"ASCII", "hello world", 5, "hello..."},
+		{"Cyrillic on a rune boundary", "привет", 4, "пр..."},
+		{"Cyrillic inside a rune", "привет", 5, "пр..."},
+		{"emoji inside a rune", "ok👍👍", 5, "ok..."},
+		{"first rune doesn't fit", "ж", 1, "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateString(tt.s, tt.maxLength)
+			if got != tt.want {
+				t.Errorf("truncateString(%q, %d) = %q, want %q", tt.s, tt.maxLength, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateString(%q, %d) = %q is not valid UTF-8", tt.s, tt.maxLength, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/inlinecache"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+const (
+	// inlineDebounce waits for the user to stop typing before spending tokens
+	inlineDebounce = 800 * time.Millisecond
+	// inlineMinQueryLength skips queries too short to be worth rewriting
+	inlineMinQueryLength = 3
+	// inlineTimeout keeps the answer inside Telegram's inline query deadline
+	inlineTimeout = 15 * time.Second
+	// inlineMarkTimeout bounds marking a query as the latest. It is shorter than
+	// inlineDebounce, so a mark can't land after the mark of a newer query.
+	inlineMarkTimeout = 500 * time.Millisecond
+	// inlineErrorText is shown instead of results when a query can't be rewritten
+	inlineErrorText = "⚠️ Couldn't rewrite right now — try again"
+)
+
+// InlineDebouncer holds inline queries back until the user stops typing, so a burst of
+// keystrokes takes a single worker instead of one per keystroke
+type InlineDebouncer struct {
+	cache *inlinecache.Cache
+
+	mu     sync.Mutex
+	timers map[int64]*time.Timer // pending query per user
+}
+
+// NewInlineDebouncer creates a debouncer that marks the latest query of each user in cache
+func NewInlineDebouncer(cache *inlinecache.Cache) *InlineDebouncer {
+	return &InlineDebouncer{
+		cache:  cache,
+		timers: make(map[int64]*time.Timer),
+	}
+}
+
+// Debounce calls submit once no newer query of the same user arrived for inlineDebounce;
+// a newer query replaces the pending one, which is then never answered: the client has
+// moved on to the newer query. Before submitting, the query is marked as the user's
+// latest in Redis, so other replicas drop the older keystrokes they received. The mark
+// is written from the timer, so it neither blocks the update loop nor lands out of order.
+func (d *InlineDebouncer) Debounce(query *tgbotapi.InlineQuery, submit func()) {
+	telegramID := query.From.ID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if pending, ok := d.timers[telegramID]; ok {
+		pending.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(inlineDebounce, func() {
+		d.mu.Lock()
+		if d.timers[telegramID] == timer {
+			delete(d.timers, telegramID)
+		}
+		d.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), inlineMarkTimeout)
+		defer cancel()
+		if err := d.cache.MarkLatest(ctx, telegramID, query.ID); err != nil {
+			log.Printf("Error marking inline query: %v", err)
+		}
+
+		submit()
+	})
+	d.timers[telegramID] = timer
+}
+
+// HandleInlineQuery rewrites "@bot draft" queries typed in any chat. Queries reach it
+// through an InlineDebouncer, so only the newest query of a user is processed once
+// they stop typing, and results are cached. Every query that reaches it is answered,
+// with an empty or error result if nothing can be shown, so the client stops waiting.
+func HandleInlineQuery(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	query *tgbotapi.InlineQuery,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	cache *inlinecache.Cache,
+) {
+	text := strings.TrimSpace(query.Query)
+	if len([]rune(text)) < inlineMinQueryLength {
+		answerInline(bot, query.ID, nil, "")
+		return
+	}
+
+	// Another replica may have received a newer keystroke while this one waited
+	if latest, err := cache.IsLatest(ctx, query.From.ID, query.ID); err != nil {
+		log.Printf("Error checking inline query: %v", err)
+	} else if !latest {
+		answerInline(bot, query.ID, nil, "")
+		return
+	}
+
+	user, err := store.GetOrCreateUser(ctx, query.From.ID, query.From.UserName, query.From.FirstName, query.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		answerInline(bot, query.ID, nil, inlineErrorText)
+		return
+	}
+
+	if cached, err := cache.GetResult(ctx, user.TelegramID, user.Style, text); err != nil {
+		log.Printf("Error reading inline cache: %v", err)
+	} else if cached != "" {
+		answerInline(bot, query.ID, inlineResults(query.ID, user.Style, cached), "")
+		return
+	}
+
+	request := claude.RewriteRequest{Style: user.Style, Text: text}
+
+	// Estimate tokens for this request
+	estimate := claudeClient.EstimateTokens(ctx, request)
+	estimatedTokens := estimate.Total()
+
+	charge, denial, err := reserveTokens(ctx, store, limiter, user, estimatedTokens, len([]rune(text)), 0)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		answerInline(bot, query.ID, nil, inlineErrorText)
+		return
+	}
+	if denial != nil {
+		answerInline(bot, query.ID, nil, inlineDenialText(denial))
+		return
+	}
+
+	apiCtx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+
+	result, err := claudeClient.RewriteToCorporate(apiCtx, request)
+	actualTokens := result.InputTokens + result.OutputTokens
+
+	// Billing must complete even if the request was cancelled by a shutdown
+	billCtx, cancelBill := detachedContext(ctx)
+	defer cancelBill()
+
+	usageLog := &storage.UsageLog{
+		UserID:          user.ID,
+		InputTokens:     result.InputTokens,
+		OutputTokens:    result.OutputTokens,
+		TotalTokens:     actualTokens,
+		MessagePreview:  truncateString(text, 500),
+		Model:           result.Model,
+		Success:         err == nil,
+		EstimatedTokens: estimatedTokens,
+		EstimateSource:  estimate.Source,
+		RequestType:     storage.RequestTypeInline,
+	}
+
+	if err != nil {
+		log.Printf("Error calling Claude API for inline query: %v", err)
+		charge.refund(billCtx, limiter)
+		if logErr := store.LogUsage(billCtx, usageLog); logErr != nil {
+			log.Printf("Error logging failed usage: %v", logErr)
+		}
+		answerInline(bot, query.ID, nil, inlineErrorText)
+		return
+	}
+
+	charge.settle(billCtx, store, limiter, actualTokens)
+
+	usageLog.ResponsePreview = truncateString(result.Text, 500)
+	if err := store.LogUsage(billCtx, usageLog); err != nil {
+		log.Printf("Error logging usage: %v", err)
+	}
+
+	if err := cache.SetResult(billCtx, user.TelegramID, user.Style, text, result.Text); err != nil {
+		log.Printf("Error caching inline result: %v", err)
+	}
+
+	log.Printf("User %d (%s) used %d tokens inline (estimated: %d, %s)", user.TelegramID, query.From.UserName, actualTokens, estimatedTokens, estimate.Source)
+
+	answerInline(bot, query.ID, inlineResults(query.ID, user.Style, result.Text), "")
+}
+
+// inlineResults wraps a rewrite into the article the user can pick
+func inlineResults(queryID, style, text string) []interface{} {
+	article := tgbotapi.NewInlineQueryResultArticle(queryID, styleName(style), text)
+	article.Description = truncateString(text, 200)
+	return []interface{}{article}
+}
+
+// answerInline sends inline results. A non-empty switchPMText shows a button that opens the bot's DM instead.
+func answerInline(bot *tgbotapi.BotAPI, queryID string, results []interface{}, switchPMText string) {
+	if results == nil {
+		results = []interface{}{}
+	}
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: queryID,
+		Results:       results,
+		CacheTime:     0,
+		IsPersonal:    true,
+	}
+	if switchPMText != "" {
+		answer.SwitchPMText = switchPMText
+		answer.SwitchPMParameter = "limit"
+	}
+
+	if _, err := bot.Request(answer); err != nil {
+		log.Printf("Error answering inline query: %v", err)
+	}
+}
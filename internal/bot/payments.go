@@ -0,0 +1,356 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/invoice"
+	"corp-bullshifter/internal/storage"
+)
+
+// starsCurrency is the only currency invoices are issued in
+const starsCurrency = "XTR"
+
+// planCallbackPrefix marks callback data produced by the /subscribe keyboard
+const planCallbackPrefix = "plan:"
+
+const (
+	// paymentTimeout bounds activating a payment, including retries and a refund
+	paymentTimeout = 30 * time.Second
+	// activationAttempts is how often activation is tried before the payment is refunded
+	activationAttempts = 3
+	// activationRetryDelay grows linearly between activation attempts
+	activationRetryDelay = time.Second
+)
+
+// formatTokens renders a token amount compactly, e.g. 1.5M or 500K
+func formatTokens(tokens int) string {
+	switch {
+	case tokens >= 1_000_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(tokens)/1_000_000), ".0") + "M"
+	case tokens >= 1_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(tokens)/1_000), ".0") + "K"
+	}
+	return fmt.Sprintf("%d", tokens)
+}
+
+// planLabel is the button text of a plan
+func planLabel(plan *storage.Plan) string {
+	if plan.Kind == storage.PlanKindTopUp {
+		return fmt.Sprintf("➕ %s · %s tokens · %d ⭐", plan.Title, formatTokens(plan.Tokens), plan.PriceStars)
+	}
+	return fmt.Sprintf("📅 %s · %s tokens / %dd · %d ⭐", plan.Title, formatTokens(plan.Tokens), int(plan.Duration.Hours()/24), plan.PriceStars)
+}
+
+// planKeyboard builds one button per plan
+func planKeyboard(plans []*storage.Plan) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(plans))
+	for _, plan := range plans {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(planLabel(plan), planCallbackPrefix+plan.ID),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// balancesStatus lists paid balances for /stats in the order they are used
+func balancesStatus(balances []*storage.Balance) string {
+	var b strings.Builder
+	b.WriteString("Paid tokens (used in this order):")
+	for _, balance := range balances {
+		if balance.Source == storage.BalanceTopUp {
+			fmt.Fprintf(&b, "\n• Top-up: %d tokens, never expires", balance.Remaining())
+		} else {
+			fmt.Fprintf(&b, "\n• Pass: %d tokens until %s", balance.Remaining(), balance.ExpiresAt.Format("2006-01-02"))
+		}
+	}
+	return b.String()
+}
+
+// HandleSubscribe handles the /subscribe command by listing the plans on sale
+func HandleSubscribe(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, store *storage.Storage) {
+	plans, err := store.ListPlans(ctx)
+	if err != nil {
+		log.Printf("Error listing plans: %v", err)
+	}
+	if len(plans) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Nothing is on sale right now. Please try again later.")
+		bot.Send(msg)
+		return
+	}
+
+	text := "💫 Pick a plan, paid with Telegram Stars:\n\n" +
+		"📅 Passes give you tokens for a limited time.\n" +
+		"➕ Top-ups never expire and are used once your pass runs out.\n\n" +
+		"Paid tokens are used before your free daily limit."
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = planKeyboard(plans)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending plans message: %v", err)
+	}
+}
+
+// handlePlanCallback sends the invoice for the plan picked from the /subscribe keyboard
+func handlePlanCallback(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	query *tgbotapi.CallbackQuery,
+	cfg *config.Config,
+	store *storage.Storage,
+	signer *invoice.Signer,
+) {
+	plan, err := store.GetPlan(ctx, strings.TrimPrefix(query.Data, planCallbackPrefix))
+	if err != nil {
+		log.Printf("Error loading plan: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Sorry, couldn't load this plan. Please try again."))
+		return
+	}
+	if plan == nil || !plan.Active || query.Message == nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, "This plan is no longer available."))
+		return
+	}
+
+	description := plan.Description
+	if description == "" {
+		description = fmt.Sprintf("%s tokens", formatTokens(plan.Tokens))
+	}
+
+	payload := signer.Sign(invoice.Payload{
+		PlanID:     plan.ID,
+		TelegramID: query.From.ID,
+		Price:      plan.PriceStars,
+		IssuedAt:   time.Now(),
+	})
+	prices := []tgbotapi.LabeledPrice{{Label: plan.Title, Amount: plan.PriceStars}}
+	inv := tgbotapi.NewInvoice(
+		query.Message.Chat.ID,
+		plan.Title,
+		description,
+		payload,
+		cfg.TelegramProviderToken,
+		"",
+		starsCurrency,
+		prices,
+	)
+
+	if _, err := bot.Send(inv); err != nil {
+		log.Printf("Error sending invoice: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Failed to start the purchase flow. Please try again later."))
+		return
+	}
+
+	answer := fmt.Sprintf("%s: %d Stars (~$%.2f)", plan.Title, plan.PriceStars, float64(plan.PriceStars)/cfg.StarsPerUSD)
+	bot.Request(tgbotapi.NewCallback(query.ID, answer))
+}
+
+// HandlePreCheckout confirms a purchase only if its invoice is genuine and still valid:
+// signed by this bot for this user, not expired, in Stars, at the current plan price,
+// and the user is allowed to buy. Otherwise Telegram shows the user why it was declined.
+func HandlePreCheckout(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	query *tgbotapi.PreCheckoutQuery,
+	store *storage.Storage,
+	signer *invoice.Signer,
+) {
+	response := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+
+	if reason := checkPurchase(ctx, query, store, signer); reason != "" {
+		log.Printf("Declining pre-checkout %s from user %d: %s", query.ID, query.From.ID, reason)
+		response.OK = false
+		response.ErrorMessage = reason
+	}
+
+	if _, err := bot.Request(response); err != nil {
+		log.Printf("Error answering pre-checkout: %v", err)
+	}
+}
+
+// checkPurchase validates a pre-checkout query and returns a user-readable reason to
+// decline it, or "" if the purchase may go ahead
+func checkPurchase(
+	ctx context.Context,
+	query *tgbotapi.PreCheckoutQuery,
+	store *storage.Storage,
+	signer *invoice.Signer,
+) string {
+	payload, err := signer.Verify(query.InvoicePayload, time.Now())
+	switch {
+	case errors.Is(err, invoice.ErrExpired):
+		return "This invoice has expired. Please use /subscribe to get a new one."
+	case err != nil:
+		return "This invoice is not valid. Please use /subscribe to get a new one."
+	}
+
+	if payload.TelegramID != query.From.ID {
+		return "This invoice was issued to someone else. Please use /subscribe to get your own."
+	}
+	if query.Currency != starsCurrency {
+		return "Only payments in Telegram Stars are accepted."
+	}
+
+	plan, err := store.GetPlan(ctx, payload.PlanID)
+	if err != nil {
+		log.Printf("Error loading plan: %v", err)
+		return "We couldn't verify this plan right now. Please try again in a minute."
+	}
+	if plan == nil || !plan.Active {
+		return "This plan is no longer available. Please use /subscribe to see the current plans."
+	}
+	if payload.Price != plan.PriceStars || query.TotalAmount != plan.PriceStars {
+		return "The price has changed since this invoice was issued. Please use /subscribe to get a new one."
+	}
+
+	user, err := store.GetOrCreateUser(ctx, query.From.ID, query.From.UserName, query.From.FirstName, query.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		return "We couldn't verify your account right now. Please try again in a minute."
+	}
+	if user.Banned {
+		return "Purchases are not available for your account. Please contact support."
+	}
+
+	return ""
+}
+
+// HandleSuccessfulPayment records the payment and grants the plan named in its invoice.
+// Activation is retried; if it still fails, or the invoice names no known plan, the
+// Stars are refunded automatically.
+func HandleSuccessfulPayment(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	cfg *config.Config,
+	store *storage.Storage,
+	signer *invoice.Signer,
+) {
+	// Activation must complete even if a shutdown cancels the job
+	payCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), paymentTimeout)
+	defer cancel()
+
+	paid := message.SuccessfulPayment
+	payment := &storage.Payment{
+		TelegramPaymentChargeID: paid.TelegramPaymentChargeID,
+		ProviderPaymentChargeID: paid.ProviderPaymentChargeID,
+		Currency:                paid.Currency,
+		TotalAmount:             paid.TotalAmount,
+		InvoicePayload:          paid.InvoicePayload,
+	}
+
+	// The payload was checked at pre-checkout; it may have expired since, which is fine
+	planID := ""
+	if payload, err := signer.Verify(paid.InvoicePayload, time.Now()); err == nil || errors.Is(err, invoice.ErrExpired) {
+		planID = payload.PlanID
+	} else {
+		log.Printf("Error verifying paid invoice %s: %v", paid.TelegramPaymentChargeID, err)
+	}
+
+	rules := storage.RenewalRules{Stack: cfg.RenewalStack, CarryOverPercent: cfg.RenewalCarryOverPercent}
+	var (
+		plan      *storage.Plan
+		granted   *storage.Balance
+		duplicate bool
+		err       error
+	)
+	for attempt := 1; attempt <= activationAttempts; attempt++ {
+		plan, granted, duplicate, err = activatePayment(payCtx, store, message.From, payment, planID, rules)
+		if err == nil {
+			break
+		}
+		log.Printf("Error processing payment %s (attempt %d/%d): %v", paid.TelegramPaymentChargeID, attempt, activationAttempts, err)
+		if attempt == activationAttempts || !waitRetry(payCtx, time.Duration(attempt)*activationRetryDelay) {
+			break
+		}
+	}
+	if duplicate {
+		log.Printf("Ignoring already processed payment %s from user %d", paid.TelegramPaymentChargeID, message.From.ID)
+		return
+	}
+
+	if err != nil || plan == nil {
+		reason := "activation failed"
+		if err == nil {
+			reason = "invoice names no known plan"
+		}
+		if !autoRefund(payCtx, bot, store, message.From.ID, payment, reason) {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Payment received, but failed to activate your plan. We'll fix it soon.")
+			bot.Send(msg)
+			return
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"Payment received, but we couldn't activate your plan, so your %d Stars were refunded. Please try again later.",
+			paid.TotalAmount))
+		bot.Send(msg)
+		return
+	}
+
+	log.Printf("User %d paid %d %s for %s (charge %s)", message.From.ID, paid.TotalAmount, paid.Currency, plan.ID, paid.TelegramPaymentChargeID)
+
+	confirmation := fmt.Sprintf("✅ %s activated!\nTokens: %d remaining\nExpires: %s",
+		plan.Title, granted.Remaining(), granted.ExpiresAt.Format("2006-01-02"))
+	if carried := granted.TokensGranted - plan.Tokens; carried > 0 {
+		confirmation += fmt.Sprintf("\nIncludes %d unused tokens carried over from your previous period.", carried)
+	}
+	if granted.Source == storage.BalanceTopUp {
+		confirmation = fmt.Sprintf("✅ %s added!\n%d tokens that never expire. They are used after your pass runs out.",
+			plan.Title, granted.Remaining())
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, confirmation)
+	bot.Send(msg)
+}
+
+// waitRetry waits before the next activation attempt. It returns false if the payment
+// ran out of time first, so the payment is refunded right away instead. A shutdown
+// doesn't stop the retries: payCtx is detached from the job.
+func waitRetry(payCtx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-payCtx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// activatePayment makes one attempt at loading the paid plan, recording the payment
+// and granting the plan. The plan is nil if planID names no known plan; the payment
+// is then only recorded.
+func activatePayment(
+	ctx context.Context,
+	store *storage.Storage,
+	from *tgbotapi.User,
+	payment *storage.Payment,
+	planID string,
+	rules storage.RenewalRules,
+) (*storage.Plan, *storage.Balance, bool, error) {
+	var plan *storage.Plan
+	if planID != "" {
+		var err error
+		if plan, err = store.GetPlan(ctx, planID); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to load plan: %w", err)
+		}
+	}
+	payment.PlanID = ""
+	if plan != nil {
+		payment.PlanID = plan.ID
+	}
+
+	user, err := store.GetOrCreateUser(ctx, from.ID, from.UserName, from.FirstName, from.LastName)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to ensure user: %w", err)
+	}
+	payment.UserID = user.ID
+
+	granted, duplicate, err := store.ProcessPayment(ctx, payment, plan, rules)
+	return plan, granted, duplicate, err
+}
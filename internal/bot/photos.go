@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// maxImageSize is the largest image the Messages API accepts
+const maxImageSize = 5 << 20
+
+// largestPhoto picks the highest resolution of the sizes Telegram generated
+func largestPhoto(sizes []tgbotapi.PhotoSize) tgbotapi.PhotoSize {
+	largest := sizes[0]
+	for _, size := range sizes[1:] {
+		if size.Width*size.Height > largest.Width*largest.Height {
+			largest = size
+		}
+	}
+	return largest
+}
+
+// downloadImage fetches a Telegram photo for the vision API. Telegram re-encodes photos as JPEG.
+func downloadImage(ctx context.Context, bot *tgbotapi.BotAPI, httpClient *http.Client, fileID string, width, height int) (*claude.Image, error) {
+	data, err := downloadFile(ctx, bot, httpClient, fileID, maxImageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &claude.Image{
+		Data:      data,
+		MediaType: "image/jpeg",
+		Width:     width,
+		Height:    height,
+	}, nil
+}
+
+// HandlePhoto drafts a professional reply to a screenshot, guided by the photo's caption
+func HandlePhoto(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+) {
+	photo := largestPhoto(message.Photo)
+	if photo.FileSize > maxImageSize {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"🖼 This image is too large. Please send screenshots up to %d MB.", maxImageSize>>20))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	image, err := downloadImage(ctx, bot, httpClient, photo.FileID, photo.Width, photo.Height)
+	if err != nil {
+		log.Printf("Error downloading photo: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't download your screenshot. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	job := rewriteJob{
+		chatID:  message.Chat.ID,
+		replyTo: message.MessageID,
+		from:    message.From,
+		user:    user,
+		request: claude.RewriteRequest{
+			Style: user.Style,
+			Text:  message.Caption,
+			Image: image,
+		},
+		photoFileID: photo.FileID,
+	}
+	runRewrite(ctx, bot, cfg, store, limiter, claudeClient, draftStore, job)
+}
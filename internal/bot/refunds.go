@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/storage"
+)
+
+// errRefundNotRecorded means the Stars were returned but the ledger couldn't be updated;
+// the payment stays marked refunding until the refund is run again
+var errRefundNotRecorded = errors.New("stars returned, but the refund was not recorded")
+
+// refundStarPayment returns the Stars of a payment to the user. The library has no
+// config type for the method, and MakeRequest takes no context, so the request is made
+// here to keep it within the caller's deadline. Telegram's refusals come back as
+// *tgbotapi.Error, like from the library.
+func refundStarPayment(ctx context.Context, bot *tgbotapi.BotAPI, telegramID int64, chargeID string) error {
+	values := url.Values{}
+	values.Set("telegram_payment_charge_id", chargeID)
+	values.Set("user_id", strconv.FormatInt(telegramID, 10))
+
+	endpoint := fmt.Sprintf(tgbotapi.APIEndpoint, bot.Token, "refundStarPayment")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create refund request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := bot.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to refund star payment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp tgbotapi.APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("failed to read refund response: %w", err)
+	}
+	if !apiResp.Ok {
+		apiErr := &tgbotapi.Error{Code: apiResp.ErrorCode, Message: apiResp.Description}
+		if apiResp.Parameters != nil {
+			apiErr.ResponseParameters = *apiResp.Parameters
+		}
+		return fmt.Errorf("failed to refund star payment: %w", apiErr)
+	}
+	return nil
+}
+
+// chargeAlreadyRefunded reports whether Telegram refused a refund because an earlier,
+// interrupted attempt already returned the Stars
+func chargeAlreadyRefunded(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "CHARGE_ALREADY_REFUNDED")
+}
+
+// issueRefund returns the Stars of a payment marked refunding by BeginRefund and then
+// completes the refund in the ledger. If Telegram refuses, the mark is cleared; if the
+// outcome is unknown, e.g. on a network error, it stays so the refund can be run again.
+// Returns the number of tokens revoked.
+func issueRefund(ctx context.Context, bot *tgbotapi.BotAPI, store *storage.Storage, payment *storage.Payment, telegramID int64) (int, error) {
+	if err := refundStarPayment(ctx, bot, telegramID, payment.TelegramPaymentChargeID); err != nil && !chargeAlreadyRefunded(err) {
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) {
+			if abortErr := store.AbortRefund(ctx, payment.ID); abortErr != nil {
+				log.Printf("Error aborting refund of payment %s: %v", payment.TelegramPaymentChargeID, abortErr)
+			}
+		}
+		return 0, err
+	}
+
+	// A concurrent attempt may have completed the refund in the meantime
+	revoked, err := store.CompleteRefund(ctx, payment.ID)
+	if errors.Is(err, storage.ErrAlreadyRefunded) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errRefundNotRecorded, err)
+	}
+	return revoked, nil
+}
+
+// autoRefund returns the Stars of a payment whose activation failed and records the
+// refund in the ledger, adding the payment if it never got there. If the ledger can't be
+// reached at all, the Stars are returned anyway and only the log records it. Returns
+// whether the Stars were returned.
+func autoRefund(ctx context.Context, bot *tgbotapi.BotAPI, store *storage.Storage, telegramID int64, payment *storage.Payment, reason string) bool {
+	chargeID := payment.TelegramPaymentChargeID
+	refund := storage.Refund{Reason: reason}
+
+	begun, err := store.BeginRefund(ctx, chargeID, refund)
+	if errors.Is(err, storage.ErrPaymentNotFound) && payment.UserID != 0 {
+		begun, err = store.BeginUnrecordedRefund(ctx, payment, refund)
+	}
+	switch {
+	case errors.Is(err, storage.ErrAlreadyRefunded):
+		return true
+	case err != nil:
+		log.Printf("Error recording refund of payment %s, refunding without a ledger entry: %v", chargeID, err)
+		if err := refundStarPayment(ctx, bot, telegramID, chargeID); err != nil && !chargeAlreadyRefunded(err) {
+			log.Printf("Error refunding payment %s of user %d: %v", chargeID, telegramID, err)
+			return false
+		}
+		log.Printf("Refunded payment %s of user %d without a ledger entry: %s", chargeID, telegramID, reason)
+		return true
+	}
+
+	if _, err := issueRefund(ctx, bot, store, begun, telegramID); err != nil {
+		log.Printf("Error refunding payment %s of user %d: %v", chargeID, telegramID, err)
+		return errors.Is(err, errRefundNotRecorded) || errors.Is(err, storage.ErrAlreadyRefunded)
+	}
+
+	log.Printf("Refunded payment %s of user %d: %s", chargeID, telegramID, reason)
+	return true
+}
+
+// HandleRefund handles the admin command /refund <charge_id> [reason]: it returns the
+// Stars, revokes or reduces what the payment bought and records the refund
+func HandleRefund(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, cfg *config.Config, store *storage.Storage) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		bot.Send(msg)
+	}
+
+	// Pretend the command doesn't exist for everyone else
+	if !cfg.IsAdmin(message.From.ID) {
+		reply("Unknown command. Use /help to see available commands.")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		reply("Usage: /refund <charge_id> [reason]")
+		return
+	}
+	chargeID := args[0]
+	reason := strings.Join(args[1:], " ")
+	if reason == "" {
+		reason = "refunded by admin"
+	}
+
+	// The refund must be recorded even if a shutdown cancels the job
+	refundCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), paymentTimeout)
+	defer cancel()
+
+	payment, err := store.BeginRefund(refundCtx, chargeID, storage.Refund{Reason: reason, RefundedBy: message.From.ID})
+	switch {
+	case errors.Is(err, storage.ErrPaymentNotFound):
+		reply(fmt.Sprintf("No payment with charge ID %s.", chargeID))
+		return
+	case errors.Is(err, storage.ErrAlreadyRefunded):
+		reply(fmt.Sprintf("Payment %s was already refunded.", chargeID))
+		return
+	case err != nil:
+		log.Printf("Error starting refund of payment %s: %v", chargeID, err)
+		reply(fmt.Sprintf("Refund of %s failed: %v", chargeID, err))
+		return
+	}
+
+	revoked, err := issueRefund(refundCtx, bot, store, payment, payment.TelegramID)
+	switch {
+	case errors.Is(err, errRefundNotRecorded):
+		log.Printf("Error recording refund of payment %s: %v", chargeID, err)
+		reply(fmt.Sprintf("⚠️ The Stars of %s were returned, but the refund couldn't be recorded: %v\n"+
+			"Run /refund %s again to revoke the tokens.", chargeID, err, chargeID))
+		return
+	case errors.Is(err, storage.ErrAlreadyRefunded):
+		reply(fmt.Sprintf("Payment %s was already refunded.", chargeID))
+		return
+	case err != nil:
+		log.Printf("Error refunding payment %s: %v", chargeID, err)
+		reply(fmt.Sprintf("Refund of %s failed: %v\nRunning /refund again is safe.", chargeID, err))
+		return
+	}
+
+	log.Printf("Admin %d refunded payment %s of user %d: %s", message.From.ID, chargeID, payment.TelegramID, reason)
+	reply(fmt.Sprintf("✅ Refunded %d %s to user %d. Revoked %d unused tokens.",
+		payment.TotalAmount, payment.Currency, payment.TelegramID, revoked))
+
+	notice := tgbotapi.NewMessage(payment.TelegramID, fmt.Sprintf(
+		"↩️ Your payment of %d Stars was refunded. The tokens it bought have been removed from your balance.",
+		payment.TotalAmount))
+	if _, err := bot.Send(notice); err != nil {
+		log.Printf("Error notifying user %d about refund: %v", payment.TelegramID, err)
+	}
+}
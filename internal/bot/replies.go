@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/conversation"
+)
+
+// isForwarded reports whether a message was forwarded from another chat
+func isForwarded(message *tgbotapi.Message) bool {
+	return message.ForwardDate != 0
+}
+
+// displayName is how a Telegram user is named in a prompt
+func displayName(user *tgbotapi.User) string {
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// forwardedAuthor names the original sender of a forwarded message, if Telegram reveals it
+func forwardedAuthor(message *tgbotapi.Message) string {
+	switch {
+	case message.ForwardFrom != nil:
+		return displayName(message.ForwardFrom)
+	case message.ForwardSenderName != "":
+		return message.ForwardSenderName
+	case message.ForwardFromChat != nil:
+		return message.ForwardFromChat.Title
+	}
+	return ""
+}
+
+// messageText is the text of a message or the caption of a media message
+func messageText(message *tgbotapi.Message) string {
+	if message.Text != "" {
+		return message.Text
+	}
+	return message.Caption
+}
+
+// replyContextFor finds the message a draft answers: the message it replies to, or a
+// message forwarded just before it. Replies to the bot's own rewrites are follow-ups,
+// not context. Returns nil for plain drafts, and whether the context is the pending
+// forwarded message, which the caller deletes once the draft was rewritten.
+func replyContextFor(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	conversations *conversation.Store,
+) (*claude.ReplyContext, bool) {
+	if quoted := message.ReplyToMessage; quoted != nil {
+		text := strings.TrimSpace(messageText(quoted))
+		fromBot := quoted.From != nil && quoted.From.ID == bot.Self.ID
+		if text != "" && !fromBot {
+			author := forwardedAuthor(quoted)
+			if author == "" && quoted.From != nil && quoted.From.ID != message.From.ID {
+				author = displayName(quoted.From)
+			}
+			return &claude.ReplyContext{Author: author, Text: text}, false
+		}
+	}
+
+	// Only peek: if the rewrite is denied or fails, the user can retry without forwarding again
+	quote, err := conversations.GetQuote(ctx, message.From.ID)
+	if err != nil {
+		log.Printf("Error loading forwarded message: %v", err)
+		return nil, false
+	}
+	if quote == nil {
+		return nil, false
+	}
+	return &claude.ReplyContext{Author: quote.Author, Text: quote.Text}, true
+}
+
+// handleForwarded keeps a forwarded message as context for the user's next message
+func handleForwarded(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, conversations *conversation.Store) {
+	quote := &conversation.Quote{
+		Author: forwardedAuthor(message),
+		Text:   strings.TrimSpace(messageText(message)),
+	}
+	if err := conversations.SaveQuote(ctx, message.From.ID, quote); err != nil {
+		log.Printf("Error saving forwarded message: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "📨 Got it. Now tell me what you really think, and I'll turn it into a professional reply.")
+	msg.ReplyToMessageID = message.MessageID
+	bot.Send(msg)
+}
@@ -0,0 +1,197 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// rewriteJob describes one rewrite to run and where to deliver the result
+type rewriteJob struct {
+	chatID  int64
+	replyTo int // message to reply to, 0 for none
+	from    *tgbotapi.User
+	user    *storage.User
+	request claude.RewriteRequest
+
+	photoFileID string // Telegram file of request.Image, so the buttons can re-run it
+}
+
+// runRewrite charges the user, calls Claude and sends the result with the rewrite action buttons.
+// It is shared by fresh drafts and by button presses that re-run a stored draft, and
+// returns the rewritten text and whether it was delivered.
+func runRewrite(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	job rewriteJob,
+) (string, bool) {
+	userID := job.from.ID
+	user := job.user
+
+	// Reject oversized drafts before spending anything on them
+	if denial := limiter.CheckMessageLength(len([]rune(job.request.Text))); denial != nil {
+		msg := tgbotapi.NewMessage(job.chatID, denialMessage(denial))
+		msg.ReplyToMessageID = job.replyTo
+		bot.Send(msg)
+		return "", false
+	}
+
+	// Estimate tokens for this request
+	estimate := claudeClient.EstimateTokens(ctx, job.request)
+	estimatedTokens := estimate.Total()
+
+	charge, denial, err := reserveTokens(ctx, store, limiter, user, estimatedTokens, len([]rune(job.request.Text)), 0)
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		errorMsg := tgbotapi.NewMessage(job.chatID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(errorMsg)
+		return "", false
+	}
+	if denial != nil {
+		log.Printf("User %d hit rate limit %s (%d/%d)", userID, denial.Rule, denial.Current, denial.Limit)
+		msg := tgbotapi.NewMessage(job.chatID, denialMessage(denial))
+		bot.Send(msg)
+		return "", false
+	}
+
+	// Show typing indicator
+	typingAction := tgbotapi.NewChatAction(job.chatID, tgbotapi.ChatTyping)
+	if _, err := bot.Request(typingAction); err != nil {
+		log.Printf("Error sending typing action: %v", err)
+	}
+
+	// Create context with timeout for Claude API (covers retries inside the client)
+	apiCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	// Call Claude API, streaming partial output into a placeholder message if enabled
+	var (
+		reply  *streamingReply
+		result *claude.Result
+	)
+	if cfg.StreamResponses {
+		reply, result, err = streamRewrite(apiCtx, bot, job.chatID, job.replyTo, claudeClient, job.request)
+	} else {
+		result, err = claudeClient.RewriteToCorporate(apiCtx, job.request)
+	}
+	rewrittenText := result.Text
+	actualTokens := result.InputTokens + result.OutputTokens
+
+	// Billing must complete even if the request was cancelled by a shutdown
+	billCtx, cancelBill := detachedContext(ctx)
+	defer cancelBill()
+
+	// Log the usage to database (even if failed), attributed to the model that served it
+	usageLog := &storage.UsageLog{
+		UserID:          user.ID,
+		InputTokens:     result.InputTokens,
+		OutputTokens:    result.OutputTokens,
+		TotalTokens:     actualTokens,
+		MessagePreview:  truncateString(messagePreview(job.request), 500),
+		ResponsePreview: "",
+		Model:           result.Model,
+		Success:         err == nil,
+		EstimatedTokens: estimatedTokens,
+		EstimateSource:  estimate.Source,
+		RequestType:     requestType(job.request),
+	}
+	if job.request.Context != nil {
+		usageLog.ContextPreview = truncateString(job.request.Context.Text, 500)
+	}
+
+	if err != nil {
+		log.Printf("Error calling Claude API: %v", err)
+
+		// Refund estimated tokens since request failed. Retries happen inside the
+		// client, so this runs exactly once per user request.
+		charge.refund(billCtx, limiter)
+
+		// Log failed request
+		if logErr := store.LogUsage(billCtx, usageLog); logErr != nil {
+			log.Printf("Error logging failed usage: %v", logErr)
+		}
+
+		errorText := userErrorMessage(err)
+		if reply != nil {
+			reply.Finish(errorText, nil)
+			return "", false
+		}
+		errorMsg := tgbotapi.NewMessage(job.chatID, errorText)
+		bot.Send(errorMsg)
+		return "", false
+	}
+
+	if !charge.settle(billCtx, store, limiter, actualTokens) {
+		warning := tgbotapi.NewMessage(job.chatID, "Your paid tokens ran out during this request. Use /subscribe to top up.")
+		bot.Send(warning)
+	}
+
+	// Update usage log with success data
+	usageLog.ResponsePreview = truncateString(rewrittenText, 500)
+	usageLog.TotalTokens = actualTokens
+
+	// Log successful request to database
+	if err := store.LogUsage(billCtx, usageLog); err != nil {
+		log.Printf("Error logging usage: %v", err)
+	}
+
+	log.Printf("User %d (%s) used %d tokens (estimated: %d, %s)", userID, job.from.UserName, actualTokens, estimatedTokens, estimate.Source)
+
+	// Send the rewritten text back with the action buttons, split if it's too long
+	keyboard := rewriteKeyboard()
+	if job.request.Mode == claude.ModeDecode {
+		keyboard = decodeKeyboard()
+	}
+	messageID, err := sendLongText(bot, job.chatID, job.replyTo, reply, rewrittenText, &keyboard)
+	if err != nil {
+		log.Printf("Error sending rewritten message: %v", err)
+		return "", false
+	}
+
+	// Remember the draft so the buttons can re-run it, now that they were delivered
+	draft := &drafts.Draft{
+		TelegramID:  userID,
+		Text:        job.request.Text,
+		Style:       job.request.Style,
+		PhotoFileID: job.photoFileID,
+		Mode:        string(job.request.Mode),
+	}
+	if job.request.Context != nil {
+		draft.ContextAuthor = job.request.Context.Author
+		draft.ContextText = job.request.Context.Text
+	}
+	if err := draftStore.Save(billCtx, job.chatID, messageID, draft); err != nil {
+		log.Printf("Error saving draft: %v", err)
+	}
+
+	return rewrittenText, true
+}
+
+// requestType is how a request is recorded in the usage log
+func requestType(request claude.RewriteRequest) string {
+	if request.Mode == claude.ModeDecode {
+		return storage.RequestTypeDecode
+	}
+	return storage.RequestTypeRewrite
+}
+
+// messagePreview describes the input of a request for the usage log
+func messagePreview(request claude.RewriteRequest) string {
+	if request.Image != nil {
+		return "[screenshot] " + request.Text
+	}
+	return request.Text
+}
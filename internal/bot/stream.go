@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+)
+
+const (
+	// streamPlaceholder is shown until the first tokens arrive
+	streamPlaceholder = "✍️ Rewriting..."
+	// streamEditInterval keeps edits well under Telegram's per-chat rate limits
+	streamEditInterval = 1200 * time.Millisecond
+	// streamCursor marks a message that is still being written
+	streamCursor = " ▌"
+)
+
+// streamingReply is a Telegram message that is progressively edited as text arrives
+type streamingReply struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+	lastEdit  time.Time
+	lastText  string
+}
+
+// newStreamingReply sends the placeholder message that later edits will replace
+func newStreamingReply(bot *tgbotapi.BotAPI, chatID int64, replyTo int) (*streamingReply, error) {
+	placeholder := tgbotapi.NewMessage(chatID, streamPlaceholder)
+	placeholder.ReplyToMessageID = replyTo
+	sent, err := bot.Send(placeholder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamingReply{
+		bot:       bot,
+		chatID:    chatID,
+		messageID: sent.MessageID,
+		lastEdit:  time.Now(),
+		lastText:  streamPlaceholder,
+	}, nil
+}
+
+// Update edits the message with partial text, at most once per streamEditInterval
+func (r *streamingReply) Update(partial string) {
+	if time.Since(r.lastEdit) < streamEditInterval {
+		return
+	}
+	// Long output is split when the stream finishes; until then show the part that fits
+	if textLength(partial) > telegramMessageLimit-partHeaderReserve {
+		partial = partial[:prefixWithin(partial, telegramMessageLimit-partHeaderReserve)]
+	}
+	r.edit(partial + streamCursor)
+}
+
+// Finish replaces the message with the final text regardless of throttling,
+// optionally attaching an inline keyboard. Returns an error if the message carrying
+// the keyboard could not be updated; failed edits without one are only logged.
+func (r *streamingReply) Finish(text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if keyboard == nil {
+		r.edit(text)
+		return nil
+	}
+
+	r.lastEdit = time.Now()
+	edit := tgbotapi.NewEditMessageTextAndMarkup(r.chatID, r.messageID, text, *keyboard)
+	if _, err := r.bot.Send(edit); err != nil {
+		return fmt.Errorf("failed to edit streamed message: %w", err)
+	}
+	r.lastText = text
+	return nil
+}
+
+func (r *streamingReply) edit(text string) {
+	if text == r.lastText {
+		return
+	}
+
+	r.lastEdit = time.Now()
+	if _, err := r.bot.Send(tgbotapi.NewEditMessageText(r.chatID, r.messageID, text)); err != nil {
+		log.Printf("Error editing streamed message: %v", err)
+		return
+	}
+	r.lastText = text
+}
+
+// streamRewrite runs a streaming rewrite and mirrors the partial output into a Telegram message.
+// The returned reply is nil if the placeholder could not be sent.
+func streamRewrite(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	chatID int64,
+	replyTo int,
+	claudeClient *claude.Client,
+	request claude.RewriteRequest,
+) (*streamingReply, *claude.Result, error) {
+	reply, err := newStreamingReply(bot, chatID, replyTo)
+	if err != nil {
+		log.Printf("Error sending streaming placeholder: %v", err)
+		result, err := claudeClient.RewriteToCorporate(ctx, request)
+		return nil, result, err
+	}
+
+	result, err := claudeClient.RewriteToCorporateStream(ctx, request, reply.Update)
+	return reply, result, err
+}
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/storage"
+)
+
+// styleCallbackPrefix marks callback data produced by the /style keyboard
+const styleCallbackPrefix = "style:"
+
+// styleKeyboard builds one button per style, marking the current one
+func styleKeyboard(current string) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(claude.Styles))
+	for _, style := range claude.Styles {
+		label := style.Name
+		if style.ID == current {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, styleCallbackPrefix+style.ID),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// styleName returns the display name of a style ID, falling back to the default style
+func styleName(id string) string {
+	if style, ok := claude.FindStyle(id); ok {
+		return style.Name
+	}
+	style, _ := claude.FindStyle(claude.DefaultStyle)
+	return style.Name
+}
+
+// HandleStyle handles the /style command
+func HandleStyle(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, store *storage.Storage) {
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't load your settings right now.")
+		bot.Send(msg)
+		return
+	}
+
+	text := fmt.Sprintf("🎨 Current style: %s\n\nPick how your messages should be rewritten:", styleName(user.Style))
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = styleKeyboard(user.Style)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending style message: %v", err)
+	}
+}
+
+// handleStyleCallback stores the style picked from the /style keyboard
+func handleStyleCallback(ctx context.Context, bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, store *storage.Storage) {
+	styleID := strings.TrimPrefix(query.Data, styleCallbackPrefix)
+
+	style, ok := claude.FindStyle(styleID)
+	if !ok {
+		bot.Request(tgbotapi.NewCallback(query.ID, "This style is no longer available."))
+		return
+	}
+
+	if _, err := store.GetOrCreateUser(ctx, query.From.ID, query.From.UserName, query.From.FirstName, query.From.LastName); err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+	}
+
+	if err := store.SetUserStyle(ctx, query.From.ID, style.ID); err != nil {
+		log.Printf("Error saving style: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "Couldn't save your style. Please try again."))
+		return
+	}
+
+	bot.Request(tgbotapi.NewCallback(query.ID, "Style set to "+style.Name))
+
+	if query.Message != nil {
+		text := fmt.Sprintf("🎨 Current style: %s\n\nPick how your messages should be rewritten:", style.Name)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, styleKeyboard(style.ID))
+		if _, err := bot.Send(edit); err != nil {
+			log.Printf("Error updating style message: %v", err)
+		}
+	}
+}
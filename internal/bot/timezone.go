@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+)
+
+// languageTimezones suggests a timezone from the Telegram client language
+var languageTimezones = map[string]string{
+	"ru": "Europe/Moscow",
+	"uk": "Europe/Kyiv",
+	"de": "Europe/Berlin",
+	"fr": "Europe/Paris",
+	"es": "Europe/Madrid",
+	"it": "Europe/Rome",
+	"pl": "Europe/Warsaw",
+	"tr": "Europe/Istanbul",
+	"ja": "Asia/Tokyo",
+	"ko": "Asia/Seoul",
+	"zh": "Asia/Shanghai",
+	"hi": "Asia/Kolkata",
+	"pt": "America/Sao_Paulo",
+	"en": "Europe/London",
+}
+
+// parseTimezone accepts an IANA name ("Asia/Tokyo") or a whole-hour UTC offset ("UTC+9", "+9")
+// and returns the name to store
+func parseTimezone(input string) (string, *time.Location, error) {
+	input = strings.TrimSpace(input)
+
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(input), "UTC"), "GMT")
+	if offset != "" && (offset[0] == '+' || offset[0] == '-') {
+		hours, err := strconv.Atoi(offset)
+		if err != nil || hours < -12 || hours > 14 {
+			return "", nil, fmt.Errorf("invalid UTC offset %q", input)
+		}
+		// Etc/GMT zones have inverted signs: Etc/GMT-9 is UTC+9
+		input = fmt.Sprintf("Etc/GMT%+d", -hours)
+		if hours == 0 {
+			input = "UTC"
+		}
+	} else if strings.EqualFold(input, "UTC") || strings.EqualFold(input, "GMT") {
+		input = "UTC"
+	}
+
+	// "Local" would silently mean the server's zone again
+	if input == "" || input == "Local" {
+		return "", nil, fmt.Errorf("invalid timezone %q", input)
+	}
+
+	loc, err := time.LoadLocation(input)
+	if err != nil {
+		return "", nil, fmt.Errorf("unknown timezone %q: %w", input, err)
+	}
+
+	return input, loc, nil
+}
+
+// HandleTimezone handles the /timezone command. Without arguments it shows the current zone.
+func HandleTimezone(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+) {
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't load your settings right now.")
+		bot.Send(msg)
+		return
+	}
+
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" {
+		example := "Europe/Berlin"
+		if suggested, ok := languageTimezones[strings.SplitN(message.From.LanguageCode, "-", 2)[0]]; ok {
+			example = suggested
+		}
+
+		loc := user.Location()
+		text := fmt.Sprintf(
+			"🕛 Your timezone: %s (local time %s)\n\n"+
+				"Your daily limit resets at midnight in this zone.\n"+
+				"Change it with /timezone <zone>, e.g. /timezone %s or /timezone UTC+3",
+			loc.String(), time.Now().In(loc).Format("15:04"), example)
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("Error sending timezone message: %v", err)
+		}
+		return
+	}
+
+	name, loc, err := parseTimezone(arg)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID,
+			"❌ I don't know that timezone. Use a name like Europe/Berlin or America/New_York, or an offset like UTC+3.")
+		bot.Send(msg)
+		return
+	}
+
+	// Carry today's usage over before switching, so the quota doesn't reset early
+	if err := limiter.MoveToTimezone(ctx, user.TelegramID, user.Location(), loc); errors.Is(err, ratelimit.ErrRequestsInFlight) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "⏳ A rewrite of yours is still running. Try changing your timezone again once it's done.")
+		bot.Send(msg)
+		return
+	} else if err != nil {
+		log.Printf("Error moving usage to new timezone: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't change your timezone right now.")
+		bot.Send(msg)
+		return
+	}
+
+	if err := store.SetUserTimezone(ctx, user.TelegramID, name); err != nil {
+		log.Printf("Error saving timezone: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't change your timezone right now.")
+		bot.Send(msg)
+		return
+	}
+
+	timeUntilReset := limiter.GetTimeUntilReset(loc)
+	text := fmt.Sprintf(
+		"✅ Timezone set to %s (local time %s).\n\nYour daily limit resets in %dh %dm.",
+		name, time.Now().In(loc).Format("15:04"), int(timeUntilReset.Hours()), int(timeUntilReset.Minutes())%60)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error sending timezone message: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"corp-bullshifter/internal/claude"
+	"corp-bullshifter/internal/config"
+	"corp-bullshifter/internal/drafts"
+	"corp-bullshifter/internal/ratelimit"
+	"corp-bullshifter/internal/storage"
+	"corp-bullshifter/internal/stt"
+)
+
+const (
+	// maxAudioSize is the largest file bots can download through the Bot API
+	maxAudioSize = 20 << 20
+	// transcriptionTimeout bounds the speech-to-text call
+	transcriptionTimeout = 2 * time.Minute
+	// speechCharsPerMinute is how much text a minute of ordinary speech transcribes to,
+	// about 130 words. It turns the rewrite input limit into a recording length.
+	speechCharsPerMinute = 800
+)
+
+// errTranscriptTooLong is returned by recognize when the transcript can't be rewritten
+var errTranscriptTooLong = errors.New("transcript exceeds the message length limit")
+
+// transcriptionTokens converts a recording's length into the tokens charged for transcribing it
+func transcriptionTokens(duration time.Duration, tokensPerMinute int) int {
+	return int(math.Ceil(duration.Minutes() * float64(tokensPerMinute)))
+}
+
+// maxVoiceDuration is the longest recording accepted: MAX_VOICE_DURATION, or less if a
+// recording that long would typically transcribe to more than maxMessageLength characters
+func maxVoiceDuration(limit time.Duration, maxMessageLength int) time.Duration {
+	if maxMessageLength <= 0 {
+		return limit
+	}
+	return min(limit, time.Duration(maxMessageLength)*time.Minute/speechCharsPerMinute)
+}
+
+// recognize transcribes audio and checks that the transcript fits the rewrite input limit.
+// A transcript that is too long is returned along with errTranscriptTooLong.
+func recognize(ctx context.Context, transcriber stt.Transcriber, audio stt.Audio, maxMessageLength int) (*stt.Transcript, error) {
+	transcript, err := transcriber.Transcribe(ctx, audio)
+	if err != nil {
+		return nil, err
+	}
+	if maxMessageLength > 0 && utf8.RuneCountInString(transcript.Text) > maxMessageLength {
+		return transcript, errTranscriptTooLong
+	}
+	return transcript, nil
+}
+
+// HandleVoice transcribes a voice message or audio file, shows the transcript and
+// rewrites it like a typed message. Transcription is charged separately, in tokens
+// proportional to the recording's length.
+func HandleVoice(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	claudeClient *claude.Client,
+	draftStore *drafts.Store,
+	transcriber stt.Transcriber,
+) {
+	if transcriber == nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "🎙 Voice messages aren't enabled on this bot yet. Please send your draft as text.")
+		bot.Send(msg)
+		return
+	}
+
+	audio := stt.Audio{FileName: "voice.ogg", MimeType: "audio/ogg"}
+	var fileID string
+	var fileSize int
+	if voice := message.Voice; voice != nil {
+		fileID, fileSize = voice.FileID, voice.FileSize
+		audio.Duration = time.Duration(voice.Duration) * time.Second
+		if voice.MimeType != "" {
+			audio.MimeType = voice.MimeType
+		}
+	} else {
+		file := message.Audio
+		fileID, fileSize = file.FileID, file.FileSize
+		audio.Duration = time.Duration(file.Duration) * time.Second
+		audio.FileName, audio.MimeType = file.FileName, file.MimeType
+	}
+
+	// Reject what couldn't be rewritten anyway before transcription is charged
+	maxDuration := maxVoiceDuration(cfg.MaxVoiceDuration, cfg.MaxMessageLength)
+	if audio.Duration > maxDuration || fileSize > maxAudioSize {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"🎙 This recording is too long. Please keep voice messages under %s.", maxDuration))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return
+	}
+
+	user, err := store.GetOrCreateUser(ctx, message.From.ID, message.From.UserName, message.From.FirstName, message.From.LastName)
+	if err != nil {
+		log.Printf("Error getting/creating user: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return
+	}
+
+	transcript, ok := transcribe(ctx, bot, message, httpClient, cfg, store, limiter, user, transcriber, fileID, audio)
+	if !ok {
+		return
+	}
+
+	// Show what was recognized before the rewrite arrives
+	if _, err := sendLongText(bot, message.Chat.ID, message.MessageID, nil, "🎙 Transcript:\n\n"+transcript, nil); err != nil {
+		log.Printf("Error sending transcript: %v", err)
+	}
+
+	job := rewriteJob{
+		chatID:  message.Chat.ID,
+		replyTo: message.MessageID,
+		from:    message.From,
+		user:    user,
+		request: claude.RewriteRequest{
+			Style: user.Style,
+			Text:  transcript,
+		},
+	}
+	runRewrite(ctx, bot, cfg, store, limiter, claudeClient, draftStore, job)
+}
+
+// transcribe bills and runs the speech-to-text call. It reports problems to the user
+// itself and returns false if there is nothing to rewrite.
+func transcribe(
+	ctx context.Context,
+	bot *tgbotapi.BotAPI,
+	message *tgbotapi.Message,
+	httpClient *http.Client,
+	cfg *config.Config,
+	store *storage.Storage,
+	limiter *ratelimit.Limiter,
+	user *storage.User,
+	transcriber stt.Transcriber,
+	fileID string,
+	audio stt.Audio,
+) (string, bool) {
+	// The price is known up front from the duration Telegram reports
+	cost := transcriptionTokens(audio.Duration, cfg.STTTokensPerMinute)
+
+	// The rewrite that follows counts the voice message against the per-minute rule,
+	// so the transcription only checks that there is room for it
+	charge, denial, err := reserve(ctx, store, limiter, user, ratelimit.Request{Tokens: cost, Uncounted: true})
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, couldn't process your request. Please try again.")
+		bot.Send(msg)
+		return "", false
+	}
+	if denial != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, denialMessage(denial))
+		bot.Send(msg)
+		return "", false
+	}
+
+	typingAction := tgbotapi.NewChatAction(message.Chat.ID, tgbotapi.ChatTyping)
+	if _, err := bot.Request(typingAction); err != nil {
+		log.Printf("Error sending typing action: %v", err)
+	}
+
+	sttCtx, cancel := context.WithTimeout(ctx, transcriptionTimeout)
+	defer cancel()
+
+	var transcript *stt.Transcript
+	audio.Data, err = downloadFile(sttCtx, bot, httpClient, fileID, maxAudioSize)
+	if err == nil {
+		transcript, err = recognize(sttCtx, transcriber, audio, cfg.MaxMessageLength)
+	}
+
+	// Billing must complete even if the request was cancelled by a shutdown
+	billCtx, cancelBill := detachedContext(ctx)
+	defer cancelBill()
+
+	usageLog := &storage.UsageLog{
+		UserID:          user.ID,
+		TotalTokens:     cost,
+		MessagePreview:  fmt.Sprintf("[voice message, %s]", audio.Duration),
+		Model:           "stt:" + cfg.STTBackend,
+		RequestType:     storage.RequestTypeTranscription,
+		Success:         err == nil,
+		EstimatedTokens: cost,
+		EstimateSource:  "audio_duration",
+	}
+
+	// A transcript too long to rewrite is not charged: the user gets nothing out of it
+	if errors.Is(err, errTranscriptTooLong) {
+		length := utf8.RuneCountInString(transcript.Text)
+		log.Printf("Rejecting transcript of user %d: %d characters, limit %d", user.TelegramID, length, cfg.MaxMessageLength)
+		charge.refund(billCtx, limiter)
+		usageLog.TotalTokens = 0
+		usageLog.Model = "stt:" + transcript.Model
+		usageLog.ResponsePreview = truncateString(transcript.Text, 500)
+		if logErr := store.LogUsage(billCtx, usageLog); logErr != nil {
+			log.Printf("Error logging failed usage: %v", logErr)
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+			"🎙 This recording transcribes to %d characters, more than the %d I can rewrite at once. "+
+				"You weren't charged for it. Please send a shorter voice message.", length, cfg.MaxMessageLength))
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return "", false
+	}
+
+	if err != nil {
+		log.Printf("Error transcribing voice message: %v", err)
+		charge.refund(billCtx, limiter)
+		usageLog.TotalTokens = 0
+		if logErr := store.LogUsage(billCtx, usageLog); logErr != nil {
+			log.Printf("Error logging failed usage: %v", logErr)
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, I couldn't understand this recording. Please try again or type your draft.")
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return "", false
+	}
+
+	charge.settle(billCtx, store, limiter, cost)
+
+	usageLog.Model = "stt:" + transcript.Model
+	usageLog.ResponsePreview = truncateString(transcript.Text, 500)
+	if err := store.LogUsage(billCtx, usageLog); err != nil {
+		log.Printf("Error logging usage: %v", err)
+	}
+
+	log.Printf("User %d (%s) transcribed %s of audio for %d tokens", user.TelegramID, message.From.UserName, audio.Duration, cost)
+
+	if transcript.Text == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "🎙 I couldn't hear any speech in this recording.")
+		msg.ReplyToMessageID = message.MessageID
+		bot.Send(msg)
+		return "", false
+	}
+
+	return transcript.Text, true
+}
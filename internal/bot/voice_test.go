@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"corp-bullshifter/internal/stt"
+)
+
+func TestMaxVoiceDuration(t *testing.T) {
+	tests := []struct {
+		name             string
+		limit            time.Duration
+		maxMessageLength int
+		want             time.Duration
+	}{
+		{"configured limit is shorter", 2 * time.Minute, 4000, 2 * time.Minute},
+		{"message limit is shorter", 10 * time.Minute, 4000, 5 * time.Minute},
+		{"message limit of 400 characters", 5 * time.Minute, 400, 30 * time.Second},
+		{"no message limit", 10 * time.Minute, 0, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxVoiceDuration(tt.limit, tt.maxMessageLength); got != tt.want {
+				t.Errorf("maxVoiceDuration(%s, %d) = %s, want %s", tt.limit, tt.maxMessageLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptionTokens(t *testing.T) {
+	if got := transcriptionTokens(90*time.Second, 1000); got != 1500 {
+		t.Errorf("transcriptionTokens(90s, 1000) = %d, want 1500", got)
+	}
+	if got := transcriptionTokens(time.Second, 1000); got != 17 {
+		t.Errorf("transcriptionTokens(1s, 1000) = %d, want 17, rounded up", got)
+	}
+}
+
+func TestRecognize(t *testing.T) {
+	audio := stt.Audio{Duration: 30 * time.Second}
+
+	transcript, err := recognize(context.Background(), stt.NewFake("please review my PR"), audio, 100)
+	if err != nil {
+		t.Fatalf("recognize: %v", err)
+	}
+	if transcript.Text != "please review my PR" {
+		t.Errorf("got transcript %q", transcript.Text)
+	}
+
+	// Characters, not bytes, are counted against the limit
+	if _, err := recognize(context.Background(), stt.NewFake(strings.Repeat("я", 100)), audio, 100); err != nil {
+		t.Errorf("100 Cyrillic characters with a limit of 100: %v", err)
+	}
+
+	long := strings.Repeat("blah ", 30)
+	transcript, err = recognize(context.Background(), stt.NewFake(long), audio, 100)
+	if !errors.Is(err, errTranscriptTooLong) {
+		t.Fatalf("got %v, want errTranscriptTooLong", err)
+	}
+	if transcript == nil || transcript.Text != long {
+		t.Errorf("a rejected transcript must still be returned for the usage log, got %+v", transcript)
+	}
+
+	failure := errors.New("backend down")
+	if _, err := recognize(context.Background(), &stt.Fake{Err: failure}, audio, 100); !errors.Is(err, failure) {
+		t.Errorf("got %v, want the backend error", err)
+	}
+}
@@ -3,23 +3,39 @@ package claude
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 )
 
 const anthropicVersion = "2023-06-01"
 
+// maxOutputTokens caps the length of a rewrite
+const maxOutputTokens = 1024
+
 // Client is a Claude API client
 type Client struct {
 	apiKey       string
 	apiURL       string
-	model        string
+	models       []string
 	httpClient   *http.Client
 	systemPrompt string
+	stylePrompts map[string]string
+	decodePrompt string
+	maxRetries   int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+
+	countTokensURL      string
+	countTokensDisabled atomic.Bool // set once the endpoint turns out to be unavailable
 }
 
 // Request represents a Claude API request
@@ -29,12 +45,13 @@ type Request struct {
 	Messages    []Message `json:"messages"`
 	System      string    `json:"system,omitempty"`
 	Temperature float64   `json:"temperature,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
 }
 
 // Message represents a message in the conversation
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
 }
 
 // Response represents a Claude API response
@@ -47,10 +64,54 @@ type Response struct {
 	Usage   Usage          `json:"usage"`
 }
 
-// ContentBlock represents a content block in the response
+// ContentBlock represents a content block of a message: text, or a base64 image in requests
 type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *ImageSource `json:"source,omitempty"`
+
+	// Image dimensions, only used to estimate tokens
+	Width  int `json:"-"`
+	Height int `json:"-"`
+}
+
+// ImageSource carries inline image data
+type ImageSource struct {
+	Type      string `json:"type"` // always "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// TextBlock creates a text content block
+func TextBlock(text string) ContentBlock {
+	return ContentBlock{Type: "text", Text: text}
+}
+
+// ImageBlock creates a base64 image content block
+func ImageBlock(image *Image) ContentBlock {
+	return ContentBlock{
+		Type: "image",
+		Source: &ImageSource{
+			Type:      "base64",
+			MediaType: image.MediaType,
+			Data:      base64.StdEncoding.EncodeToString(image.Data),
+		},
+		Width:  image.Width,
+		Height: image.Height,
+	}
+}
+
+// NewTextMessage creates a message with a single text block
+func NewTextMessage(role, text string) Message {
+	return Message{Role: role, Content: []ContentBlock{TextBlock(text)}}
+}
+
+// Result is the outcome of a rewrite call
+type Result struct {
+	Text         string
+	InputTokens  int
+	OutputTokens int
+	Model        string // model that actually served the request
 }
 
 // Usage represents token usage information
@@ -59,8 +120,10 @@ type Usage struct {
 	OutputTokens int `json:"output_tokens"`
 }
 
-// New creates a new Claude API client
-func New(apiKey, apiURL, model string, httpClient *http.Client) *Client {
+// New creates a new Claude API client.
+// models is an ordered fallback chain: the first entry is the primary model,
+// the rest are tried in turn when a model is overloaded or not found.
+func New(apiKey, apiURL string, models []string, httpClient *http.Client) *Client {
 	// Load system prompt from file
 	promptPath := os.Getenv("PROMPT_FILE")
 	if promptPath == "" {
@@ -76,9 +139,16 @@ func New(apiKey, apiURL, model string, httpClient *http.Client) *Client {
 	return &Client{
 		apiKey:       apiKey,
 		apiURL:       apiURL,
-		model:        model,
+		models:       models,
 		httpClient:   httpClient,
 		systemPrompt: string(promptBytes),
+		stylePrompts: loadStylePrompts(filepath.Join(filepath.Dir(promptPath), "styles")),
+		decodePrompt: loadDecodePrompt(filepath.Dir(promptPath)),
+		maxRetries:   defaultMaxRetries,
+		baseDelay:    defaultBaseDelay,
+		maxDelay:     defaultMaxDelay,
+
+		countTokensURL: countTokensURL(apiURL),
 	}
 }
 
@@ -93,30 +163,96 @@ Rules:
 - Output only the rewritten text`
 }
 
-// RewriteToCorporate rewrites text into polite corporate style
-// Returns: (rewritten text, input tokens, output tokens, error)
-func (c *Client) RewriteToCorporate(ctx context.Context, text string) (string, int, int, error) {
-	reqBody := Request{
-		Model:     c.model,
-		MaxTokens: 1024,
-		System:    c.systemPrompt,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: text,
-			},
-		},
+// newRequest builds an API request for a rewrite.
+// The model is filled in by sendWithFallback.
+func (c *Client) newRequest(rewrite RewriteRequest) Request {
+	messages := make([]Message, 0, len(rewrite.History)+1)
+	messages = append(messages, rewrite.History...)
+	messages = append(messages, Message{
+		Role:    "user",
+		Content: rewrite.content(),
+	})
+
+	return Request{
+		MaxTokens:   maxOutputTokens,
+		System:      c.systemPromptForRequest(rewrite),
+		Messages:    messages,
 		Temperature: 0.7,
 	}
+}
+
+// sendWithFallback walks the model chain until one model accepts the request.
+// Only overload and not-found errors move on to the next model; anything else
+// is returned as is. Returns the response and the model that served it.
+func (c *Client) sendWithFallback(ctx context.Context, reqBody Request) (*http.Response, string, error) {
+	var lastErr error
+
+	for i, model := range c.models {
+		hasFallback := i < len(c.models)-1
+		reqBody.Model = model
+
+		resp, err := c.send(ctx, reqBody, !hasFallback)
+		if err == nil {
+			return resp, model, nil
+		}
+
+		lastErr = err
+		if !hasFallback || ctx.Err() != nil || !(errors.Is(err, ErrOverloaded) || errors.Is(err, ErrNotFound)) {
+			return nil, model, err
+		}
+
+		log.Printf("Claude model %s unavailable, falling back to %s: %v", model, c.models[i+1], err)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no Claude models configured")
+	}
+	return nil, "", lastErr
+}
 
+// send posts a request to the Messages API and returns the raw HTTP response.
+// Retryable failures (rate limits, overload, server errors, timeouts) are repeated
+// with backoff until maxRetries is exhausted or ctx is done. Overload is only
+// retried when retryOverloaded is set, so a fallback model can be tried instead.
+// Non-200 responses are turned into *APIError; the caller must close the body otherwise.
+func (c *Client) send(ctx context.Context, reqBody Request, retryOverloaded bool) (*http.Response, error) {
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL, bytes.NewBuffer(jsonData))
+	for attempt := 0; ; attempt++ {
+		resp, err := c.sendOnce(ctx, c.apiURL, jsonData)
+		if err == nil {
+			return resp, nil
+		}
+
+		if attempt >= c.maxRetries || !isRetryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		if !retryOverloaded && errors.Is(err, ErrOverloaded) {
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.RetryAfter
+		}
+
+		wait := c.backoff(attempt, retryAfter)
+		log.Printf("Claude API call failed (attempt %d/%d), retrying in %s: %v", attempt+1, c.maxRetries+1, wait, err)
+		if !sleep(ctx, wait) {
+			return nil, err
+		}
+	}
+}
+
+// sendOnce performs a single HTTP round trip to url
+func (c *Client) sendOnce(ctx context.Context, url string, jsonData []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -125,27 +261,45 @@ func (c *Client) RewriteToCorporate(ctx context.Context, text string) (string, i
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", classifyTransportError(err))
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newAPIError(resp, body)
+	}
+
+	return resp, nil
+}
+
+// RewriteToCorporate rewrites text into polite corporate style using the requested style and modifier.
+// The returned Result is never nil; on failure it carries the model that was last tried.
+func (c *Client) RewriteToCorporate(ctx context.Context, rewrite RewriteRequest) (*Result, error) {
+	resp, model, err := c.sendWithFallback(ctx, c.newRequest(rewrite))
+	result := &Result{Model: model}
 	if err != nil {
-		return "", 0, 0, fmt.Errorf("failed to read response: %w", err)
+		return result, err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", 0, 0, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return result, fmt.Errorf("failed to read response: %w", classifyTransportError(err))
 	}
 
 	var claudeResp Response
 	if err := json.Unmarshal(body, &claudeResp); err != nil {
-		return "", 0, 0, fmt.Errorf("failed to unmarshal response: %w", err)
+		return result, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	result.InputTokens = claudeResp.Usage.InputTokens
+	result.OutputTokens = claudeResp.Usage.OutputTokens
+
 	if len(claudeResp.Content) == 0 {
-		return "", 0, 0, fmt.Errorf("no content in response")
+		return result, fmt.Errorf("no content in response")
 	}
 
-	return claudeResp.Content[0].Text, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens, nil
+	result.Text = claudeResp.Content[0].Text
+	return result, nil
 }
@@ -0,0 +1,48 @@
+package claude
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Mode is what a request does with the user's text
+type Mode string
+
+const (
+	// ModeRewrite turns a raw draft into a professional message
+	ModeRewrite Mode = ""
+	// ModeDecode turns corporate speak into plain language
+	ModeDecode Mode = "decode"
+)
+
+// decodePromptFile holds the reverse-mode prompt, next to the main prompt
+const decodePromptFile = "decode_prompt.txt"
+
+// decodeOutputTokens is the least a decoded summary is expected to take
+const decodeOutputTokens = 200
+
+// loadDecodePrompt reads the reverse-mode prompt from dir, falling back to a built-in one
+func loadDecodePrompt(dir string) string {
+	path := filepath.Join(dir, decodePromptFile)
+	promptBytes, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Warning: failed to load decode prompt from %s: %v. Using default decode prompt.", path, err)
+		return getDefaultDecodePrompt()
+	}
+	return string(promptBytes)
+}
+
+// getDefaultDecodePrompt returns a fallback reverse-mode prompt if the file is not found
+func getDefaultDecodePrompt() string {
+	return `You translate vague corporate messages into blunt plain language.
+
+Output:
+- What is actually being asked, in one or two sentences
+- Deadlines, stated or implied
+- Any hidden "no", blame or bad news
+- Match the input language
+- Output only the summary, nothing else
+
+Decode this message:`
+}
@@ -0,0 +1,151 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error classes returned by the client. Use errors.Is to match them:
+//
+//	if errors.Is(err, claude.ErrOverloaded) { ... }
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrOverloaded     = errors.New("overloaded")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrAuth           = errors.New("authentication failed")
+	ErrNotFound       = errors.New("not found")
+	ErrTimeout        = errors.New("timeout")
+	ErrServer         = errors.New("server error")
+)
+
+// APIError is an error response returned by the Anthropic API
+type APIError struct {
+	StatusCode int
+	Type       string // e.g. "overloaded_error"
+	Message    string
+	RetryAfter time.Duration // zero if the server didn't send retry-after
+	kind       error
+}
+
+// errorEnvelope is the JSON body Anthropic sends with non-200 responses
+type errorEnvelope struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("API returned status %d (%s): %s", e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap exposes the error class so callers can use errors.Is
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError builds an APIError from a non-200 response and its body
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("retry-after")),
+	}
+
+	var envelope errorEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error.Type != "" {
+		apiErr.Type = envelope.Error.Type
+		apiErr.Message = envelope.Error.Message
+	}
+
+	apiErr.kind = classify(resp.StatusCode, apiErr.Type)
+	return apiErr
+}
+
+// newStreamError builds an APIError from an error event received mid-stream
+func newStreamError(streamErr *StreamError) *APIError {
+	return &APIError{
+		StatusCode: http.StatusOK,
+		Type:       streamErr.Type,
+		Message:    streamErr.Message,
+		kind:       classify(0, streamErr.Type),
+	}
+}
+
+// classify maps an HTTP status and Anthropic error type to an error class
+func classify(statusCode int, errorType string) error {
+	switch errorType {
+	case "rate_limit_error":
+		return ErrRateLimited
+	case "overloaded_error":
+		return ErrOverloaded
+	case "invalid_request_error", "request_too_large":
+		return ErrInvalidRequest
+	case "authentication_error", "permission_error":
+		return ErrAuth
+	case "not_found_error":
+		return ErrNotFound
+	case "api_error":
+		return ErrServer
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == 529:
+		return ErrOverloaded
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrAuth
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case statusCode >= 400 && statusCode < 500:
+		return ErrInvalidRequest
+	default:
+		return ErrServer
+	}
+}
+
+// parseRetryAfter reads a retry-after header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// classifyTransportError marks transport-level timeouts with ErrTimeout
+func classifyTransportError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// isRetryable reports whether a failed call may succeed if repeated
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, ErrServer) ||
+		errors.Is(err, ErrTimeout)
+}
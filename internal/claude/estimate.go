@@ -0,0 +1,207 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// countTokensTimeout keeps estimation from delaying the rewrite noticeably
+	countTokensTimeout = 3 * time.Second
+	// messageOverheadTokens covers role markers and formatting around each message
+	messageOverheadTokens = 10
+	// minOutputTokens is reserved even for one-word drafts
+	minOutputTokens = 64
+)
+
+// Estimate sources
+const (
+	EstimateCountTokens = "count_tokens"
+	EstimateHeuristic   = "heuristic"
+)
+
+// Estimate is the expected token usage of a rewrite
+type Estimate struct {
+	InputTokens  int
+	OutputTokens int
+	Source       string // EstimateCountTokens or EstimateHeuristic
+}
+
+// Total returns the expected input plus output tokens
+func (e Estimate) Total() int {
+	return e.InputTokens + e.OutputTokens
+}
+
+// countTokensRequest is the body of the count_tokens endpoint
+type countTokensRequest struct {
+	Model    string    `json:"model"`
+	System   string    `json:"system,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+// countTokensResponse is the reply of the count_tokens endpoint
+type countTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// countTokensURL derives the count_tokens endpoint from the Messages API URL.
+// Returns "" if apiURL doesn't look like a Messages endpoint, e.g. a custom proxy.
+func countTokensURL(apiURL string) string {
+	trimmed := strings.TrimSuffix(apiURL, "/")
+	if !strings.HasSuffix(trimmed, "/messages") {
+		return ""
+	}
+	return trimmed + "/count_tokens"
+}
+
+// EstimateTokens predicts the tokens a rewrite will use. Input tokens come from the
+// count_tokens endpoint when it is available and from a local heuristic otherwise;
+// output tokens are always predicted from the length of the draft.
+func (c *Client) EstimateTokens(ctx context.Context, rewrite RewriteRequest) Estimate {
+	estimate := c.EstimateTokensLocal(rewrite)
+
+	if c.countTokensURL == "" || c.countTokensDisabled.Load() || len(c.models) == 0 {
+		return estimate
+	}
+
+	req := c.newRequest(rewrite)
+	countCtx, cancel := context.WithTimeout(ctx, countTokensTimeout)
+	defer cancel()
+
+	counted, err := c.countTokens(countCtx, countTokensRequest{
+		Model:    c.models[0],
+		System:   req.System,
+		Messages: req.Messages,
+	})
+	if err != nil {
+		// A proxy or an old API version without the endpoint: stop asking
+		if errors.Is(err, ErrNotFound) {
+			c.countTokensDisabled.Store(true)
+		}
+		log.Printf("Warning: token counting failed, using heuristic estimate: %v", err)
+		return estimate
+	}
+
+	estimate.InputTokens = counted
+	estimate.Source = EstimateCountTokens
+	return estimate
+}
+
+// EstimateTokensLocal predicts the tokens of a rewrite with the local heuristic only,
+// for callers that estimate many requests at once and can't wait for count_tokens
+func (c *Client) EstimateTokensLocal(rewrite RewriteRequest) Estimate {
+	req := c.newRequest(rewrite)
+	return Estimate{
+		InputTokens:  heuristicTokens(req.System) + heuristicMessageTokens(req.Messages),
+		OutputTokens: expectedOutputTokens(rewrite),
+		Source:       EstimateHeuristic,
+	}
+}
+
+// countTokens asks the API for the exact input token count of a request. It is not
+// retried: the heuristic is a good enough answer when the endpoint is slow or failing.
+func (c *Client) countTokens(ctx context.Context, reqBody countTokensRequest) (int, error) {
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal count_tokens request: %w", err)
+	}
+
+	resp, err := c.sendOnce(ctx, c.countTokensURL, jsonData)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read count_tokens response: %w", classifyTransportError(err))
+	}
+
+	var countResp countTokensResponse
+	if err := json.Unmarshal(body, &countResp); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal count_tokens response: %w", err)
+	}
+
+	return countResp.InputTokens, nil
+}
+
+// heuristicMessageTokens estimates the input tokens of the conversation messages, including images
+func heuristicMessageTokens(messages []Message) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += messageOverheadTokens
+		for _, block := range message.Content {
+			if block.Type == "image" {
+				tokens += imageTokens(block.Width, block.Height)
+			} else {
+				tokens += heuristicTokens(block.Text)
+			}
+		}
+	}
+	return tokens
+}
+
+// heuristicTokens approximates the token count of text. English averages about
+// four characters per token; Cyrillic and other non-Latin scripts split into
+// considerably more tokens, so they are counted at about two characters per token.
+func heuristicTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + (other+1)/2
+}
+
+// expectedOutputTokens predicts the length of the rewrite from the draft. Rewrites
+// are usually about as long as the draft, plus some room for the corporate padding.
+func expectedOutputTokens(rewrite RewriteRequest) int {
+	// A reply to a screenshot doesn't depend on the length of the caption
+	if rewrite.Image != nil {
+		return screenshotOutputTokens
+	}
+
+	// A follow-up instruction revises the previous rewrite, so that sets the length
+	base := heuristicTokens(rewrite.Text)
+	if n := len(rewrite.History); n > 0 {
+		for _, block := range rewrite.History[n-1].Content {
+			if previous := heuristicTokens(block.Text); previous > base {
+				base = previous
+			}
+		}
+	}
+
+	tokens := base * 3 / 2
+	if rewrite.Context != nil && tokens < replyOutputTokens {
+		// A reply is sized by what it answers more than by the raw reaction
+		tokens = replyOutputTokens
+	}
+	if rewrite.Mode == ModeDecode {
+		// A decoded summary is shorter than the message, but has a fixed shape
+		tokens = base
+		if tokens < decodeOutputTokens {
+			tokens = decodeOutputTokens
+		}
+	}
+	if rewrite.Modifier == ModifierShorter {
+		tokens /= 2
+	}
+
+	if tokens < minOutputTokens {
+		tokens = minOutputTokens
+	}
+	if tokens > maxOutputTokens {
+		tokens = maxOutputTokens
+	}
+	return tokens
+}
@@ -0,0 +1,18 @@
+package claude
+
+// historyInstruction explains how earlier turns relate to the latest message
+const historyInstruction = "Earlier messages in this conversation are the user's previous drafts or instructions and your rewrites. " +
+	"If the latest message is an instruction about the previous rewrite (for example \"make it shorter\" or \"now in English\"), " +
+	"apply it to your previous rewrite and output only the revised text. Otherwise treat the latest message as a new draft."
+
+// TrimHistory drops the oldest turns until the history fits in maxTokens. Turns are
+// dropped in user/assistant pairs so the history always starts with a user message.
+func TrimHistory(history []Message, maxTokens int) []Message {
+	for len(history) > 0 && heuristicMessageTokens(history) > maxTokens {
+		history = history[1:]
+		for len(history) > 0 && history[0].Role != "user" {
+			history = history[1:]
+		}
+	}
+	return history
+}
@@ -0,0 +1,70 @@
+package claude
+
+// Modifier tweaks a rewrite of the same draft (shorter, softer...)
+type Modifier string
+
+const (
+	ModifierNone             Modifier = ""
+	ModifierShorter          Modifier = "shorter"
+	ModifierMoreFormal       Modifier = "formal"
+	ModifierSofter           Modifier = "softer"
+	ModifierOriginalLanguage Modifier = "original_language"
+)
+
+// modifierInstructions are appended to the system prompt for each modifier
+var modifierInstructions = map[Modifier]string{
+	ModifierShorter:          "Make the result noticeably shorter than a normal rewrite while keeping the key point.",
+	ModifierMoreFormal:       "Make the result more formal and reserved than a normal rewrite.",
+	ModifierSofter:           "Make the result softer, warmer and more tactful than a normal rewrite.",
+	ModifierOriginalLanguage: "Write the result in exactly the same language as the user's draft, even if other instructions suggest otherwise.",
+}
+
+// IsValid reports whether m is a known modifier
+func (m Modifier) IsValid() bool {
+	if m == ModifierNone {
+		return true
+	}
+	_, ok := modifierInstructions[m]
+	return ok
+}
+
+// documentPartInstruction keeps a rewritten document paragraph from turning into a standalone message
+const documentPartInstruction = "The text is one part of a longer document. Rewrite only this part and return only the rewritten part: " +
+	"no greetings, sign-offs or comments unless they are in the text, and keep headings and list items as short as they are."
+
+// RewriteRequest describes a single rewrite call
+type RewriteRequest struct {
+	Style        string        // style preset ID, see Styles
+	Mode         Mode          // rewrite (default) or decode
+	Text         string        // the user's original draft
+	Modifier     Modifier      // optional tweak on top of the style
+	DocumentPart bool          // Text is a paragraph of an uploaded document
+	Image        *Image        // screenshot to reply to; Text is then the optional caption
+	Context      *ReplyContext // message to reply to; Text is then the user's raw reaction
+	History      []Message     // earlier turns of the conversation, oldest first
+}
+
+// systemPromptForRequest builds the system prompt for a request: the style prompt (or the
+// decode prompt in reverse mode) plus any modifier instruction
+func (c *Client) systemPromptForRequest(req RewriteRequest) string {
+	prompt := c.systemPromptFor(req.Style)
+	if req.Mode == ModeDecode {
+		prompt = c.decodePrompt
+	}
+	if instruction, ok := modifierInstructions[req.Modifier]; ok {
+		prompt += "\n\nAdditional requirement: " + instruction
+	}
+	if req.DocumentPart {
+		prompt += "\n\nAdditional requirement: " + documentPartInstruction
+	}
+	if req.Image != nil {
+		prompt += "\n\nAdditional requirement: " + screenshotInstruction
+	}
+	if req.Context != nil {
+		prompt += "\n\nAdditional requirement: " + replyInstruction
+	}
+	if len(req.History) > 0 {
+		prompt += "\n\n" + historyInstruction
+	}
+	return prompt
+}
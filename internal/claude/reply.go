@@ -0,0 +1,41 @@
+package claude
+
+import "strings"
+
+const (
+	// replyInstruction turns the rewrite into a reply to a quoted message
+	replyInstruction = "The user is replying to the quoted message from someone else. Their own text is their raw reaction, " +
+		"not the message to rephrase. Write the professional reply the user should send to the quoted message, " +
+		"saying what their reaction means. Output only the reply."
+
+	// replyOutputTokens is the least a reply to a quoted message is expected to take
+	replyOutputTokens = 300
+)
+
+// ReplyContext is a message the user is answering: one they replied to or forwarded to the bot
+type ReplyContext struct {
+	Author string // sender's name, empty if unknown
+	Text   string
+}
+
+// prompt combines the quoted message with the user's raw reaction into one user message
+func (c *ReplyContext) prompt(reaction string) string {
+	var b strings.Builder
+	b.WriteString("Message I'm replying to")
+	if c.Author != "" {
+		b.WriteString(" (from " + c.Author + ")")
+	}
+	b.WriteString(":\n\"\"\"\n" + c.Text + "\n\"\"\"\n\n")
+	b.WriteString("My raw reaction:\n" + reaction)
+	return b.String()
+}
+
+// UserText is the text of the user message sent for the request: the draft, or the
+// draft under the message it replies to. Follow-ups keep it in the history, so they
+// still know what was being answered.
+func (r RewriteRequest) UserText() string {
+	if r.Context != nil && r.Image == nil {
+		return r.Context.prompt(r.Text)
+	}
+	return r.Text
+}
@@ -0,0 +1,50 @@
+package claude
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// defaultMaxRetries is how many times a retryable failure is repeated
+	defaultMaxRetries = 3
+	// defaultBaseDelay is the backoff before the first retry
+	defaultBaseDelay = 500 * time.Millisecond
+	// defaultMaxDelay caps a single backoff step
+	defaultMaxDelay = 8 * time.Second
+)
+
+// backoff returns the wait before retry number attempt (0-based).
+// A server-provided retry-after wins, capped at maxDelay so a long one can't hold the
+// caller past its deadline; otherwise exponential backoff with full jitter is used.
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, c.maxDelay)
+	}
+
+	ceiling := c.baseDelay << attempt
+	if ceiling <= 0 || ceiling > c.maxDelay {
+		ceiling = c.maxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+// It returns false if the wait cannot complete before the context deadline.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,119 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StreamHandler receives the text accumulated so far every time a new delta arrives
+type StreamHandler func(partial string)
+
+// StreamEvent represents a single server-sent event payload from the Messages API
+type StreamEvent struct {
+	Type    string       `json:"type"`
+	Message *Response    `json:"message,omitempty"`
+	Delta   *StreamDelta `json:"delta,omitempty"`
+	Usage   *Usage       `json:"usage,omitempty"`
+	Error   *StreamError `json:"error,omitempty"`
+}
+
+// StreamDelta carries incremental content (content_block_delta) or stop info (message_delta)
+type StreamDelta struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// StreamError is the error payload sent mid-stream
+type StreamError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// RewriteToCorporateStream rewrites text like RewriteToCorporate, but consumes the
+// response as server-sent events and reports partial text through onText.
+// On failure the Result carries whatever text and usage arrived before the error.
+func (c *Client) RewriteToCorporateStream(ctx context.Context, rewrite RewriteRequest, onText StreamHandler) (*Result, error) {
+	reqBody := c.newRequest(rewrite)
+	reqBody.Stream = true
+
+	resp, model, err := c.sendWithFallback(ctx, reqBody)
+	result := &Result{Model: model}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		builder   strings.Builder
+		eventName string
+	)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			eventName = ""
+			continue
+		case strings.HasPrefix(line, "event:"):
+			eventName = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		case !strings.HasPrefix(line, "data:"):
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			result.Text = builder.String()
+			return result, fmt.Errorf("failed to unmarshal %s event: %w", eventName, err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				result.InputTokens = event.Message.Usage.InputTokens
+				result.OutputTokens = event.Message.Usage.OutputTokens
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				builder.WriteString(event.Delta.Text)
+				if onText != nil {
+					onText(builder.String())
+				}
+			}
+		case "message_delta":
+			// Output token count in message_delta is cumulative for the whole message
+			if event.Usage != nil {
+				result.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			result.Text = builder.String()
+			if event.Error != nil {
+				return result, newStreamError(event.Error)
+			}
+			return result, fmt.Errorf("stream error: %s", data)
+		case "message_stop":
+			result.Text = builder.String()
+			if builder.Len() == 0 {
+				return result, fmt.Errorf("no content in response")
+			}
+			return result, nil
+		}
+	}
+
+	result.Text = builder.String()
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("failed to read stream: %w", classifyTransportError(err))
+	}
+
+	return result, fmt.Errorf("stream ended before message_stop")
+}
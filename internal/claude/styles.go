@@ -0,0 +1,67 @@
+package claude
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DefaultStyle is the style backed by the main system prompt file
+const DefaultStyle = "default"
+
+// Style is a named rewrite preset backed by its own prompt file
+type Style struct {
+	ID   string
+	Name string
+}
+
+// Styles lists the available presets in the order they are shown to users.
+// Every style except DefaultStyle is loaded from styles/<ID>.txt next to the main prompt.
+var Styles = []Style{
+	{ID: DefaultStyle, Name: "✨ Default"},
+	{ID: "formal_email", Name: "📧 Formal email"},
+	{ID: "slack", Name: "💬 Slack-friendly"},
+	{ID: "diplomatic_no", Name: "🙅 Diplomatic no"},
+	{ID: "executive_summary", Name: "📊 Executive summary"},
+	{ID: "apology", Name: "🙏 Apology"},
+}
+
+// FindStyle looks up a style by ID
+func FindStyle(id string) (Style, bool) {
+	for _, style := range Styles {
+		if style.ID == id {
+			return style, true
+		}
+	}
+	return Style{}, false
+}
+
+// loadStylePrompts reads the prompt of every non-default style from dir.
+// Styles whose file is missing are skipped and fall back to the default prompt.
+func loadStylePrompts(dir string) map[string]string {
+	prompts := make(map[string]string, len(Styles))
+
+	for _, style := range Styles {
+		if style.ID == DefaultStyle {
+			continue
+		}
+
+		path := filepath.Join(dir, style.ID+".txt")
+		promptBytes, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to load %s style prompt from %s: %v. Using default prompt.", style.ID, path, err)
+			continue
+		}
+		prompts[style.ID] = string(promptBytes)
+	}
+
+	return prompts
+}
+
+// systemPromptFor returns the system prompt for a style, falling back to the default prompt
+func (c *Client) systemPromptFor(style string) string {
+	if prompt, ok := c.stylePrompts[style]; ok {
+		return prompt
+	}
+	return c.systemPrompt
+}
@@ -0,0 +1,50 @@
+package claude
+
+const (
+	// screenshotInstruction turns the rewrite into a reply to a screenshot
+	screenshotInstruction = "The user sent a screenshot of a conversation, such as a Slack or email thread. " +
+		"Do not describe the image. Write the professional reply the user should send to what is shown. " +
+		"If the user added a note, use it as guidance for what the reply should say."
+	// screenshotDefaultText is sent next to a screenshot without a caption
+	screenshotDefaultText = "Draft my reply to this conversation."
+
+	// maxImageTokens is what the largest image costs after the API downscales it
+	maxImageTokens = 1600
+	// screenshotOutputTokens is the expected length of a reply to a screenshot
+	screenshotOutputTokens = 400
+)
+
+// Image is a picture attached to a rewrite request
+type Image struct {
+	Data      []byte
+	MediaType string // e.g. "image/jpeg"
+	Width     int
+	Height    int
+}
+
+// content builds the user message: the draft, the draft under the message it replies to,
+// or a screenshot followed by its caption
+func (r RewriteRequest) content() []ContentBlock {
+	if r.Image == nil {
+		return []ContentBlock{TextBlock(r.UserText())}
+	}
+
+	text := screenshotDefaultText
+	if r.Text != "" {
+		text = "Guidance for the reply: " + r.Text
+	}
+	return []ContentBlock{ImageBlock(r.Image), TextBlock(text)}
+}
+
+// imageTokens approximates the tokens of an image the way the API documents it:
+// about width*height/750, capped at the size images are scaled down to
+func imageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return maxImageTokens
+	}
+	tokens := width * height / 750
+	if tokens > maxImageTokens {
+		tokens = maxImageTokens
+	}
+	return tokens
+}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all application configuration
@@ -13,9 +17,51 @@ type Config struct {
 	ClaudeAPIKey          string
 	ClaudeAPIURL          string
 	ClaudeModel           string
+	ClaudeModels          []string // ClaudeModel followed by fallbacks, in order
 	DatabaseURL           string
 	RedisURL              string
 	StarsPerUSD           float64
+	InvoiceSecret         string        // HMAC key for invoice payloads
+	InvoiceTTL            time.Duration // how long an invoice can be paid
+	AdminIDs              []int64       // Telegram users allowed to run admin commands like /refund
+	StreamResponses       bool
+
+	// Renewal of a subscription that is still active
+	RenewalStack            bool // new period starts at the current end date
+	RenewalCarryOverPercent int  // share of unused tokens kept, 0-100
+
+	// Update delivery: long polling (default) or webhook
+	UpdateMode              string
+	WebhookURL              string // public HTTPS URL Telegram posts updates to
+	WebhookListenAddr       string
+	WebhookSecret           string
+	WebhookDeleteOnShutdown bool
+
+	// Update processing
+	WorkerPoolSize  int
+	WorkerQueueSize int
+	ShutdownTimeout time.Duration
+
+	// Rate limit policy; 0 disables a rule
+	RequestsPerMinute     int
+	MaxMessageLength      int
+	MaxConcurrentRequests int
+
+	// Document rewriting
+	MaxDocumentSize   int // bytes
+	MaxDocumentChunks int
+
+	// Speech-to-text for voice messages
+	STTBackend         string // "" disables voice messages
+	STTAPIURL          string
+	STTAPIKey          string
+	STTModel           string
+	MaxVoiceDuration   time.Duration
+	STTTokensPerMinute int // usage charged per minute of audio
+
+	// Conversation context for follow-up instructions
+	ConversationTTL  time.Duration
+	HistoryMaxTokens int
 }
 
 const (
@@ -28,6 +74,59 @@ const (
 
 	// DefaultStarsPerUSD is an approximate conversion rate Telegram uses for Stars purchases
 	DefaultStarsPerUSD = 65.0
+
+	// UpdateModePolling fetches updates with getUpdates long polling
+	UpdateModePolling = "polling"
+	// UpdateModeWebhook receives updates through an HTTP server
+	UpdateModeWebhook = "webhook"
+	// DefaultWebhookListenAddr is where the webhook server listens
+	DefaultWebhookListenAddr = ":8080"
+
+	// DefaultWorkerPoolSize is the number of updates handled concurrently
+	DefaultWorkerPoolSize = 16
+	// DefaultWorkerQueueSize is the number of updates that may wait for a worker
+	DefaultWorkerQueueSize = 256
+	// DefaultShutdownTimeout is how long in-flight requests may finish on shutdown
+	DefaultShutdownTimeout = 20 * time.Second
+
+	// DefaultRequestsPerMinute caps requests per user in a sliding minute
+	DefaultRequestsPerMinute = 10
+	// DefaultMaxMessageLength caps the characters of a single message
+	DefaultMaxMessageLength = 4000
+	// DefaultMaxConcurrentRequests caps requests per user processed at once
+	DefaultMaxConcurrentRequests = 2
+
+	// DefaultMaxDocumentSize caps uploaded documents, in bytes
+	DefaultMaxDocumentSize = 512 * 1024
+	// DefaultMaxDocumentChunks caps the number of rewrite calls for one document
+	DefaultMaxDocumentChunks = 40
+
+	// STTBackendWhisper transcribes through an OpenAI-compatible Whisper endpoint
+	STTBackendWhisper = "whisper"
+	// STTBackendFake returns a fixed transcript, for development and tests
+	STTBackendFake = "fake"
+	// DefaultSTTAPIURL is OpenAI's transcription endpoint; self-hosted Whisper servers expose the same API
+	DefaultSTTAPIURL = "https://api.openai.com/v1/audio/transcriptions"
+	// DefaultSTTModel is the transcription model requested by default
+	DefaultSTTModel = "whisper-1"
+	// DefaultMaxVoiceDuration caps the length of voice messages
+	DefaultMaxVoiceDuration = 5 * time.Minute
+	// DefaultSTTTokensPerMinute is the usage charged per minute of audio,
+	// roughly what a minute of Whisper costs in Claude Haiku tokens
+	DefaultSTTTokensPerMinute = 1500
+
+	// DefaultInvoiceTTL is how long an invoice stays payable after /subscribe
+	DefaultInvoiceTTL = 24 * time.Hour
+
+	// DefaultRenewalCarryOverPercent keeps all unused tokens on an early renewal
+	DefaultRenewalCarryOverPercent = 100
+
+	// DefaultConversationTTL is how long a conversation is remembered without activity
+	DefaultConversationTTL = 30 * time.Minute
+	// DefaultHistoryMaxTokens caps the history sent with each request
+	DefaultHistoryMaxTokens = 2000
+	// ConversationMaxTurns is the number of messages kept per conversation
+	ConversationMaxTurns = 10
 )
 
 // Load reads configuration from environment variables
@@ -41,6 +140,37 @@ func Load() (*Config, error) {
 		DatabaseURL:           os.Getenv("DATABASE_URL"),
 		RedisURL:              os.Getenv("REDIS_URL"),
 		StarsPerUSD:           DefaultStarsPerUSD,
+		InvoiceSecret:         os.Getenv("INVOICE_SECRET"),
+		InvoiceTTL:            DefaultInvoiceTTL,
+
+		RenewalStack:            true,
+		RenewalCarryOverPercent: DefaultRenewalCarryOverPercent,
+
+		UpdateMode:        os.Getenv("UPDATE_MODE"),
+		WebhookURL:        os.Getenv("WEBHOOK_URL"),
+		WebhookListenAddr: os.Getenv("WEBHOOK_LISTEN_ADDR"),
+		WebhookSecret:     os.Getenv("WEBHOOK_SECRET"),
+
+		WorkerPoolSize:  DefaultWorkerPoolSize,
+		WorkerQueueSize: DefaultWorkerQueueSize,
+		ShutdownTimeout: DefaultShutdownTimeout,
+
+		RequestsPerMinute:     DefaultRequestsPerMinute,
+		MaxMessageLength:      DefaultMaxMessageLength,
+		MaxConcurrentRequests: DefaultMaxConcurrentRequests,
+
+		MaxDocumentSize:   DefaultMaxDocumentSize,
+		MaxDocumentChunks: DefaultMaxDocumentChunks,
+
+		STTBackend:         os.Getenv("STT_BACKEND"),
+		STTAPIURL:          os.Getenv("STT_API_URL"),
+		STTAPIKey:          os.Getenv("STT_API_KEY"),
+		STTModel:           os.Getenv("STT_MODEL"),
+		MaxVoiceDuration:   DefaultMaxVoiceDuration,
+		STTTokensPerMinute: DefaultSTTTokensPerMinute,
+
+		ConversationTTL:  DefaultConversationTTL,
+		HistoryMaxTokens: DefaultHistoryMaxTokens,
 	}
 
 	// Validate required fields
@@ -65,11 +195,166 @@ func Load() (*Config, error) {
 		cfg.ClaudeModel = DefaultClaudeModel
 	}
 
+	cfg.ClaudeModels = []string{cfg.ClaudeModel}
+	for _, model := range strings.Split(os.Getenv("CLAUDE_FALLBACK_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" && model != cfg.ClaudeModel {
+			cfg.ClaudeModels = append(cfg.ClaudeModels, model)
+		}
+	}
+
+	for _, idRaw := range strings.Split(os.Getenv("ADMIN_IDS"), ",") {
+		if idRaw = strings.TrimSpace(idRaw); idRaw == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(idRaw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ADMIN_IDS must be comma-separated Telegram user IDs, got %q", idRaw)
+		}
+		cfg.AdminIDs = append(cfg.AdminIDs, id)
+	}
+
 	if starsRaw := os.Getenv("STARS_PER_USD"); starsRaw != "" {
 		if parsed, err := strconv.ParseFloat(starsRaw, 64); err == nil && parsed > 0 {
 			cfg.StarsPerUSD = parsed
 		}
 	}
 
+	// Without an explicit secret, invoices are signed with a key derived from the bot token
+	if cfg.InvoiceSecret == "" {
+		sum := sha256.Sum256([]byte("invoice:" + cfg.TelegramToken))
+		cfg.InvoiceSecret = hex.EncodeToString(sum[:])
+	}
+	if ttlRaw := os.Getenv("INVOICE_TTL"); ttlRaw != "" {
+		if parsed, err := time.ParseDuration(ttlRaw); err == nil && parsed > 0 {
+			cfg.InvoiceTTL = parsed
+		}
+	}
+
+	if stackRaw := os.Getenv("RENEWAL_STACK"); stackRaw != "" {
+		if parsed, err := strconv.ParseBool(stackRaw); err == nil {
+			cfg.RenewalStack = parsed
+		}
+	}
+	if carryRaw := os.Getenv("RENEWAL_CARRYOVER_PERCENT"); carryRaw != "" {
+		parsed, err := strconv.Atoi(carryRaw)
+		if err != nil || parsed < 0 || parsed > 100 {
+			return nil, fmt.Errorf("RENEWAL_CARRYOVER_PERCENT must be between 0 and 100, got %q", carryRaw)
+		}
+		cfg.RenewalCarryOverPercent = parsed
+	}
+
+	if streamRaw := os.Getenv("CLAUDE_STREAM"); streamRaw != "" {
+		if parsed, err := strconv.ParseBool(streamRaw); err == nil {
+			cfg.StreamResponses = parsed
+		}
+	}
+
+	if cfg.UpdateMode == "" {
+		cfg.UpdateMode = UpdateModePolling
+	}
+	switch cfg.UpdateMode {
+	case UpdateModePolling:
+	case UpdateModeWebhook:
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL environment variable is required in webhook mode")
+		}
+		if cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("WEBHOOK_SECRET environment variable is required in webhook mode")
+		}
+	default:
+		return nil, fmt.Errorf("UPDATE_MODE must be %q or %q, got %q", UpdateModePolling, UpdateModeWebhook, cfg.UpdateMode)
+	}
+	if cfg.WebhookListenAddr == "" {
+		cfg.WebhookListenAddr = DefaultWebhookListenAddr
+	}
+	if deleteRaw := os.Getenv("WEBHOOK_DELETE_ON_SHUTDOWN"); deleteRaw != "" {
+		if parsed, err := strconv.ParseBool(deleteRaw); err == nil {
+			cfg.WebhookDeleteOnShutdown = parsed
+		}
+	}
+
+	if workersRaw := os.Getenv("WORKER_POOL_SIZE"); workersRaw != "" {
+		if parsed, err := strconv.Atoi(workersRaw); err == nil && parsed > 0 {
+			cfg.WorkerPoolSize = parsed
+		}
+	}
+	if queueRaw := os.Getenv("WORKER_QUEUE_SIZE"); queueRaw != "" {
+		if parsed, err := strconv.Atoi(queueRaw); err == nil && parsed > 0 {
+			cfg.WorkerQueueSize = parsed
+		}
+	}
+	if timeoutRaw := os.Getenv("SHUTDOWN_TIMEOUT"); timeoutRaw != "" {
+		if parsed, err := time.ParseDuration(timeoutRaw); err == nil && parsed > 0 {
+			cfg.ShutdownTimeout = parsed
+		}
+	}
+
+	// Rate limit rules accept 0 to disable them
+	for env, target := range map[string]*int{
+		"RATE_LIMIT_PER_MINUTE":   &cfg.RequestsPerMinute,
+		"MAX_MESSAGE_LENGTH":      &cfg.MaxMessageLength,
+		"MAX_CONCURRENT_REQUESTS": &cfg.MaxConcurrentRequests,
+	} {
+		if raw := os.Getenv(env); raw != "" {
+			if parsed, err := strconv.Atoi(raw); err == nil && parsed >= 0 {
+				*target = parsed
+			}
+		}
+	}
+
+	if sizeRaw := os.Getenv("MAX_DOCUMENT_SIZE"); sizeRaw != "" {
+		if parsed, err := strconv.Atoi(sizeRaw); err == nil && parsed > 0 {
+			cfg.MaxDocumentSize = parsed
+		}
+	}
+	if chunksRaw := os.Getenv("MAX_DOCUMENT_CHUNKS"); chunksRaw != "" {
+		if parsed, err := strconv.Atoi(chunksRaw); err == nil && parsed > 0 {
+			cfg.MaxDocumentChunks = parsed
+		}
+	}
+
+	switch cfg.STTBackend {
+	case "", STTBackendWhisper, STTBackendFake:
+	default:
+		return nil, fmt.Errorf("STT_BACKEND must be empty, %q or %q, got %q", STTBackendWhisper, STTBackendFake, cfg.STTBackend)
+	}
+	if cfg.STTAPIURL == "" {
+		cfg.STTAPIURL = DefaultSTTAPIURL
+	}
+	if cfg.STTModel == "" {
+		cfg.STTModel = DefaultSTTModel
+	}
+	if durationRaw := os.Getenv("MAX_VOICE_DURATION"); durationRaw != "" {
+		if parsed, err := time.ParseDuration(durationRaw); err == nil && parsed > 0 {
+			cfg.MaxVoiceDuration = parsed
+		}
+	}
+	if costRaw := os.Getenv("STT_TOKENS_PER_MINUTE"); costRaw != "" {
+		if parsed, err := strconv.Atoi(costRaw); err == nil && parsed >= 0 {
+			cfg.STTTokensPerMinute = parsed
+		}
+	}
+
+	if ttlRaw := os.Getenv("CONVERSATION_TTL"); ttlRaw != "" {
+		if parsed, err := time.ParseDuration(ttlRaw); err == nil && parsed > 0 {
+			cfg.ConversationTTL = parsed
+		}
+	}
+	if historyRaw := os.Getenv("HISTORY_MAX_TOKENS"); historyRaw != "" {
+		if parsed, err := strconv.Atoi(historyRaw); err == nil && parsed >= 0 {
+			cfg.HistoryMaxTokens = parsed
+		}
+	}
+
 	return cfg, nil
 }
+
+// IsAdmin reports whether a Telegram user may run admin commands
+func (c *Config) IsAdmin(telegramID int64) bool {
+	for _, id := range c.AdminIDs {
+		if id == telegramID {
+			return true
+		}
+	}
+	return false
+}
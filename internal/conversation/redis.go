@@ -0,0 +1,171 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Roles of a turn, matching the Messages API
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// quoteTTL is how long a forwarded message waits for the user's reaction
+const quoteTTL = 10 * time.Minute
+
+// Store keeps a short per-user history of drafts, rewrites and follow-up instructions
+type Store struct {
+	client   *redis.Client
+	ttl      time.Duration
+	maxTurns int
+}
+
+// Turn is one message of the conversation
+type Turn struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// Quote is a forwarded message waiting for the user's reaction to it
+type Quote struct {
+	Author string `json:"author,omitempty"`
+	Text   string `json:"text"`
+}
+
+// New creates a new Store instance. A conversation is forgotten after ttl without
+// activity, and only the last maxTurns turns are kept.
+func New(redisURL string, ttl time.Duration, maxTurns int) (*Store, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log.Println("Conversation store connected to Redis")
+
+	return &Store{client: client, ttl: ttl, maxTurns: maxTurns}, nil
+}
+
+// Close closes the Redis connection
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+// getConversationKey generates a Redis key for a user's conversation
+func (s *Store) getConversationKey(telegramID int64) string {
+	return fmt.Sprintf("conversation:%d", telegramID)
+}
+
+// getQuoteKey generates a Redis key for a user's pending forwarded message
+func (s *Store) getQuoteKey(telegramID int64) string {
+	return fmt.Sprintf("conversation:%d:quote", telegramID)
+}
+
+// Get returns the user's conversation, oldest turn first
+func (s *Store) Get(ctx context.Context, telegramID int64) ([]Turn, error) {
+	items, err := s.client.LRange(ctx, s.getConversationKey(telegramID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	turns := make([]Turn, 0, len(items))
+	for _, item := range items {
+		var turn Turn
+		if err := json.Unmarshal([]byte(item), &turn); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal conversation turn: %w", err)
+		}
+		turns = append(turns, turn)
+	}
+
+	return turns, nil
+}
+
+// Append adds turns, drops the oldest beyond maxTurns and refreshes the TTL in one transaction
+func (s *Store) Append(ctx context.Context, telegramID int64, turns ...Turn) error {
+	items := make([]interface{}, 0, len(turns))
+	for _, turn := range turns {
+		data, err := json.Marshal(turn)
+		if err != nil {
+			return fmt.Errorf("failed to marshal conversation turn: %w", err)
+		}
+		items = append(items, data)
+	}
+
+	key := s.getConversationKey(telegramID)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, items...)
+		pipe.LTrim(ctx, key, int64(-s.maxTurns), -1)
+		pipe.Expire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to append to conversation: %w", err)
+	}
+
+	return nil
+}
+
+// SaveQuote remembers a forwarded message until the user sends their reaction,
+// replacing any earlier one
+func (s *Store) SaveQuote(ctx context.Context, telegramID int64, quote *Quote) error {
+	data, err := json.Marshal(quote)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quote: %w", err)
+	}
+
+	if err := s.client.Set(ctx, s.getQuoteKey(telegramID), data, quoteTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save quote: %w", err)
+	}
+
+	return nil
+}
+
+// GetQuote returns the pending forwarded message, or nil if there is none. It stays
+// pending until DeleteQuote, so a reaction that couldn't be rewritten can be sent again.
+func (s *Store) GetQuote(ctx context.Context, telegramID int64) (*Quote, error) {
+	data, err := s.client.Get(ctx, s.getQuoteKey(telegramID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quote: %w", err)
+	}
+
+	quote := &Quote{}
+	if err := json.Unmarshal(data, quote); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal quote: %w", err)
+	}
+
+	return quote, nil
+}
+
+// DeleteQuote forgets the pending forwarded message once the reaction to it was rewritten
+func (s *Store) DeleteQuote(ctx context.Context, telegramID int64) error {
+	if err := s.client.Del(ctx, s.getQuoteKey(telegramID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete quote: %w", err)
+	}
+	return nil
+}
+
+// Reset forgets the user's conversation and any pending forwarded message
+func (s *Store) Reset(ctx context.Context, telegramID int64) error {
+	if err := s.client.Del(ctx, s.getConversationKey(telegramID), s.getQuoteKey(telegramID)).Err(); err != nil {
+		return fmt.Errorf("failed to reset conversation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is a supported document type
+type Format string
+
+const (
+	FormatText     Format = "txt"
+	FormatMarkdown Format = "md"
+	FormatDOCX     Format = "docx"
+)
+
+// ErrUnsupported is returned for files that are not plain text, Markdown or DOCX
+var ErrUnsupported = errors.New("unsupported document format")
+
+// Block is one piece of a document. Only Text is rewritten; Prefix and Suffix keep the
+// surrounding structure (Markdown markers, blank lines) exactly as they were.
+type Block struct {
+	Prefix  string
+	Text    string
+	Suffix  string
+	Rewrite bool // false for code, tables and other text that must stay untouched
+}
+
+// Document is a parsed file that can be rendered back into its original format
+type Document struct {
+	Format Format
+	Blocks []Block
+
+	docx *docxSource // original archive, needed to render DOCX
+}
+
+// DetectFormat picks the format from the file extension, falling back to the MIME type
+func DetectFormat(fileName, mimeType string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".txt", ".text":
+		return FormatText, nil
+	case ".md", ".markdown":
+		return FormatMarkdown, nil
+	case ".docx":
+		return FormatDOCX, nil
+	}
+
+	switch mimeType {
+	case "text/plain":
+		return FormatText, nil
+	case "text/markdown", "text/x-markdown":
+		return FormatMarkdown, nil
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return FormatDOCX, nil
+	}
+
+	return "", ErrUnsupported
+}
+
+// Parse splits a file into blocks
+func Parse(format Format, data []byte) (*Document, error) {
+	switch format {
+	case FormatText, FormatMarkdown:
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("failed to parse document: text is not valid UTF-8")
+		}
+		text := strings.TrimPrefix(string(data), "\ufeff")
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		if format == FormatMarkdown {
+			return &Document{Format: format, Blocks: parseMarkdown(text)}, nil
+		}
+		return &Document{Format: format, Blocks: parseText(text)}, nil
+	case FormatDOCX:
+		return parseDOCX(data)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Render builds a file in the document's original format from the current blocks
+func (d *Document) Render() ([]byte, error) {
+	if d.Format == FormatDOCX {
+		return d.renderDOCX()
+	}
+
+	var b strings.Builder
+	for _, block := range d.Blocks {
+		b.WriteString(block.Prefix)
+		b.WriteString(block.Text)
+		b.WriteString(block.Suffix)
+	}
+	return []byte(b.String()), nil
+}
+
+// RewritableBlocks returns the indexes of the blocks that should be rewritten
+func (d *Document) RewritableBlocks() []int {
+	var indexes []int
+	for i, block := range d.Blocks {
+		if block.Rewrite && strings.TrimSpace(block.Text) != "" {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// parseText splits plain text into paragraphs separated by blank lines
+func parseText(text string) []Block {
+	var blocks []Block
+	lines := strings.SplitAfter(text, "\n")
+
+	var paragraph strings.Builder
+	flush := func(suffix string) {
+		if paragraph.Len() == 0 {
+			if len(blocks) > 0 {
+				blocks[len(blocks)-1].Suffix += suffix
+			} else if suffix != "" {
+				blocks = append(blocks, Block{Suffix: suffix})
+			}
+			return
+		}
+		body := strings.TrimRight(paragraph.String(), "\n")
+		blocks = append(blocks, Block{
+			Text:    body,
+			Suffix:  paragraph.String()[len(body):] + suffix,
+			Rewrite: true,
+		})
+		paragraph.Reset()
+	}
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			flush(line)
+			continue
+		}
+		paragraph.WriteString(line)
+	}
+	flush("")
+
+	return blocks
+}
@@ -0,0 +1,126 @@
+package document
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		fileName string
+		mimeType string
+		want     Format
+		wantErr  bool
+	}{
+		{"notes.TXT", "", FormatText, false},
+		{"README.markdown", "", FormatMarkdown, false},
+		{"report.docx", "application/octet-stream", FormatDOCX, false},
+		{"upload", "text/markdown", FormatMarkdown, false},
+		{"report.pdf", "application/pdf", "", true},
+		{"report.doc", "application/msword", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			got, err := DetectFormat(tt.fileName, tt.mimeType)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupported) {
+					t.Errorf("DetectFormat(%q, %q) error = %v, want ErrUnsupported", tt.fileName, tt.mimeType, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("DetectFormat(%q, %q) = %q, %v; want %q", tt.fileName, tt.mimeType, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseText(t *testing.T) {
+	long := strings.Repeat("Очень длинный абзац без пустых строк. ", 2000)
+
+	tests := []struct {
+		name   string
+		input  string
+		want   []Block
+		render string // expected output of Render; the input if empty
+	}{
+		{
+			name:  "paragraphs separated by blank lines",
+			input: "First line\nsecond line\n\n\nNext paragraph\n",
+			want: []Block{
+				{Text: "First line\nsecond line", Suffix: "\n\n\n", Rewrite: true},
+				{Text: "Next paragraph", Suffix: "\n", Rewrite: true},
+			},
+		},
+		{
+			name:   "byte order mark and CRLF",
+			input:  "\ufeffHello\r\n\r\nWorld",
+			want:   []Block{{Text: "Hello", Suffix: "\n\n", Rewrite: true}, {Text: "World", Rewrite: true}},
+			render: "Hello\n\nWorld",
+		},
+		{
+			name:  "Cyrillic and emoji",
+			input: "Привет 👋\n\nКоллеги, дедлайн завтра.",
+			want: []Block{
+				{Text: "Привет 👋", Suffix: "\n\n", Rewrite: true},
+				{Text: "Коллеги, дедлайн завтра.", Rewrite: true},
+			},
+		},
+		{
+			name:  "whitespace-only lines separate paragraphs",
+			input: "one\n  \t\ntwo",
+			want:  []Block{{Text: "one", Suffix: "\n  \t\n", Rewrite: true}, {Text: "two", Rewrite: true}},
+		},
+		{
+			name:  "only blank lines",
+			input: "\n\n",
+			want:  []Block{{Suffix: "\n\n"}},
+		},
+		{
+			name:  "one oversize paragraph stays one block",
+			input: long,
+			want:  []Block{{Text: long, Rewrite: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(FormatText, []byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			assertBlocks(t, doc.Blocks, tt.want)
+
+			want := tt.render
+			if want == "" {
+				want = tt.input
+			}
+			rendered, err := doc.Render()
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if string(rendered) != want {
+				t.Errorf("Render = %q, want %q", rendered, want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidUTF8(t *testing.T) {
+	for _, format := range []Format{FormatText, FormatMarkdown} {
+		if _, err := Parse(format, []byte("caf\xe9 in Latin-1")); err == nil {
+			t.Errorf("Parse(%s) accepted invalid UTF-8", format)
+		}
+	}
+}
+
+func TestRewritableBlocks(t *testing.T) {
+	doc, err := Parse(FormatMarkdown, []byte("# Title\n\n```\ncode\n```\n\n- item\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	got := doc.RewritableBlocks()
+	if len(got) != 2 || doc.Blocks[got[0]].Text != "Title" || doc.Blocks[got[1]].Text != "item" {
+		t.Errorf("RewritableBlocks = %v of %+v, want the heading and the list item", got, doc.Blocks)
+	}
+}
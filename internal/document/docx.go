@@ -0,0 +1,190 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	// docxBodyPath is the part of a DOCX archive that holds the main text
+	docxBodyPath = "word/document.xml"
+	// maxDOCXBodySize guards against archives that decompress into huge documents
+	maxDOCXBodySize = 20 << 20
+)
+
+var (
+	// An empty paragraph may be self-closing; it must not run on into the next one
+	docxParagraphPattern = regexp.MustCompile(`(?s)<w:p(?:\s[^>]*)?/>|<w:p[ >].*?</w:p>`)
+	docxNestedPattern    = regexp.MustCompile(`<w:p[ >]`)
+	// Run content: text, line breaks and tab characters. Tab stops in the paragraph
+	// properties also use <w:tab>, but always with attributes, so they don't match.
+	docxTextPattern = regexp.MustCompile(`<w:t(?:\s[^>]*)?>([^<]*)</w:t>|<w:t(?:\s[^>]*)?/>|(<w:(?:br|cr)(?:\s[^>]*)?/>)|(<w:tab/>)`)
+)
+
+// docxSource keeps what is needed to put rewritten text back into the original archive
+type docxSource struct {
+	archive    *zip.Reader
+	body       string
+	paragraphs [][2]int // byte span of each <w:p> in body, one per block
+	original   []string // paragraph text as extracted, to skip unchanged paragraphs
+}
+
+// parseDOCX extracts one block per paragraph of word/document.xml. Paragraph and run
+// properties stay in the XML, so headings, lists and tables keep their formatting.
+func parseDOCX(data []byte) (*Document, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DOCX archive: %w", err)
+	}
+
+	var bodyFile *zip.File
+	for _, f := range archive.File {
+		if f.Name == docxBodyPath {
+			bodyFile = f
+			break
+		}
+	}
+	if bodyFile == nil {
+		return nil, fmt.Errorf("failed to parse DOCX: %s not found", docxBodyPath)
+	}
+
+	r, err := bodyFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DOCX body: %w", err)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(io.LimitReader(r, maxDOCXBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DOCX body: %w", err)
+	}
+	if len(body) > maxDOCXBodySize {
+		return nil, fmt.Errorf("failed to parse DOCX: body is larger than %d bytes", maxDOCXBodySize)
+	}
+
+	src := &docxSource{archive: archive, body: string(body)}
+	doc := &Document{Format: FormatDOCX, docx: src}
+
+	for _, span := range docxParagraphPattern.FindAllStringIndex(src.body, -1) {
+		paragraph := src.body[span[0]:span[1]]
+		text := docxParagraphText(paragraph)
+
+		// Paragraphs inside text boxes would make the span cover two paragraphs; leave them alone
+		nested := len(docxNestedPattern.FindAllStringIndex(paragraph, 2)) > 1
+
+		src.paragraphs = append(src.paragraphs, [2]int{span[0], span[1]})
+		src.original = append(src.original, text)
+		doc.Blocks = append(doc.Blocks, Block{Text: text, Rewrite: !nested})
+	}
+
+	return doc, nil
+}
+
+// docxParagraphText joins the text runs of a paragraph
+func docxParagraphText(paragraph string) string {
+	var b strings.Builder
+	for _, m := range docxTextPattern.FindAllStringSubmatch(paragraph, -1) {
+		switch {
+		case m[2] != "":
+			b.WriteByte('\n')
+		case m[3] != "":
+			b.WriteByte('\t')
+		default:
+			b.WriteString(html.UnescapeString(m[1]))
+		}
+	}
+	return b.String()
+}
+
+// docxRunContent renders text as run content, turning line breaks and tabs back into
+// <w:br/> and <w:tab/>
+func docxRunContent(text string) string {
+	var b strings.Builder
+	start := 0
+	writeText := func(end int) {
+		if end > start {
+			b.WriteString(`<w:t xml:space="preserve">`)
+			xml.EscapeText(&b, []byte(text[start:end]))
+			b.WriteString(`</w:t>`)
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			writeText(i)
+			b.WriteString(`<w:br/>`)
+			start = i + 1
+		case '\t':
+			writeText(i)
+			b.WriteString(`<w:tab/>`)
+			start = i + 1
+		}
+	}
+	writeText(len(text))
+	if b.Len() == 0 {
+		return `<w:t></w:t>`
+	}
+	return b.String()
+}
+
+// replaceDOCXParagraphText puts text into the first run of a paragraph and empties the
+// others, so the paragraph keeps the formatting of its first run
+func replaceDOCXParagraphText(paragraph, text string) string {
+	first := true
+	return docxTextPattern.ReplaceAllStringFunc(paragraph, func(string) string {
+		if first {
+			first = false
+			return docxRunContent(text)
+		}
+		return ""
+	})
+}
+
+// renderDOCX writes the archive back with the rewritten paragraphs
+func (d *Document) renderDOCX() ([]byte, error) {
+	src := d.docx
+
+	var body strings.Builder
+	last := 0
+	for i, span := range src.paragraphs {
+		paragraph := src.body[span[0]:span[1]]
+		if d.Blocks[i].Text != src.original[i] {
+			paragraph = replaceDOCXParagraphText(paragraph, d.Blocks[i].Text)
+		}
+		body.WriteString(src.body[last:span[0]])
+		body.WriteString(paragraph)
+		last = span[1]
+	}
+	body.WriteString(src.body[last:])
+
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	for _, f := range src.archive.File {
+		if f.Name != docxBodyPath {
+			if err := w.Copy(f); err != nil {
+				return nil, fmt.Errorf("failed to copy %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		header := f.FileHeader
+		fw, err := w.CreateHeader(&header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write DOCX body: %w", err)
+		}
+		if _, err := io.WriteString(fw, body.String()); err != nil {
+			return nil, fmt.Errorf("failed to write DOCX body: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write DOCX archive: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
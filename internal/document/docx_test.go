@@ -0,0 +1,205 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// buildDOCX returns a minimal DOCX archive whose body holds the given paragraphs
+func buildDOCX(t *testing.T, paragraphs string) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0"?><Types/>`},
+		{docxBodyPath, `<?xml version="1.0"?><w:document><w:body>` + paragraphs + `<w:sectPr/></w:body></w:document>`},
+	}
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatalf("Create %s: %v", f.name, err)
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			t.Fatalf("Write %s: %v", f.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return out.Bytes()
+}
+
+// readDOCXBody returns word/document.xml of a rendered archive
+func readDOCXBody(t *testing.T, data []byte) string {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("rendered archive: %v", err)
+	}
+	for _, f := range archive.File {
+		if f.Name != docxBodyPath {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer r.Close()
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		return string(body)
+	}
+	t.Fatalf("rendered archive has no %s", docxBodyPath)
+	return ""
+}
+
+func TestParseDOCX(t *testing.T) {
+	tests := []struct {
+		name        string
+		paragraphs  string
+		wantText    []string
+		wantRewrite []bool
+	}{
+		{
+			name:        "runs are joined",
+			paragraphs:  `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>Hello </w:t></w:r><w:r><w:t xml:space="preserve">world</w:t></w:r></w:p>`,
+			wantText:    []string{"Hello world"},
+			wantRewrite: []bool{true},
+		},
+		{
+			name:        "paragraph properties are not a paragraph",
+			paragraphs:  `<w:p w:rsidR="00A1"><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Title</w:t></w:r></w:p><w:p><w:r><w:t>Body</w:t></w:r></w:p>`,
+			wantText:    []string{"Title", "Body"},
+			wantRewrite: []bool{true, true},
+		},
+		{
+			name:        "empty and self-closing paragraphs",
+			paragraphs:  `<w:p/><w:p w:rsidR="00A1"/><w:p></w:p><w:p><w:r><w:t/></w:r></w:p><w:p><w:r><w:t>Text</w:t></w:r></w:p>`,
+			wantText:    []string{"", "", "", "", "Text"},
+			wantRewrite: []bool{true, true, true, true, true},
+		},
+		{
+			name:        "XML entities",
+			paragraphs:  `<w:p><w:r><w:t>Q&amp;A &lt;draft&gt; &quot;ASAP&quot; &#x2014; &#8470;1</w:t></w:r></w:p>`,
+			wantText:    []string{`Q&A <draft> "ASAP" — №1`},
+			wantRewrite: []bool{true},
+		},
+		{
+			name:        "line breaks and tabs",
+			paragraphs:  `<w:p><w:pPr><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr><w:r><w:t>one</w:t><w:br/><w:t>two</w:t><w:tab/><w:t>three</w:t><w:br w:type="page"/></w:r></w:p>`,
+			wantText:    []string{"one\ntwo\tthree\n"},
+			wantRewrite: []bool{true},
+		},
+		{
+			name:        "paragraph in a text box",
+			paragraphs:  `<w:p><w:r><w:t>Outer</w:t><w:txbxContent><w:p><w:r><w:t>Inner</w:t></w:r></w:p></w:txbxContent></w:r></w:p>`,
+			wantText:    []string{"OuterInner"},
+			wantRewrite: []bool{false},
+		},
+		{
+			name:        "Cyrillic",
+			paragraphs:  `<w:p><w:r><w:t>Привет, коллеги!</w:t></w:r></w:p>`,
+			wantText:    []string{"Привет, коллеги!"},
+			wantRewrite: []bool{true},
+		},
+		{
+			name:       "no paragraphs",
+			paragraphs: ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(FormatDOCX, buildDOCX(t, tt.paragraphs))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(doc.Blocks) != len(tt.wantText) {
+				t.Fatalf("got %d blocks, want %d: %+v", len(doc.Blocks), len(tt.wantText), doc.Blocks)
+			}
+			for i, block := range doc.Blocks {
+				if block.Text != tt.wantText[i] {
+					t.Errorf("block %d text = %q, want %q", i, block.Text, tt.wantText[i])
+				}
+				if block.Rewrite != tt.wantRewrite[i] {
+					t.Errorf("block %d rewrite = %v, want %v", i, block.Rewrite, tt.wantRewrite[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseDOCXErrors(t *testing.T) {
+	valid := buildDOCX(t, `<w:p><w:r><w:t>Text</w:t></w:r></w:p>`)
+
+	var noBody bytes.Buffer
+	w := zip.NewWriter(&noBody)
+	if _, err := w.Create("word/styles.xml"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	w.Close()
+
+	var oversize bytes.Buffer
+	w = zip.NewWriter(&oversize)
+	fw, err := w.Create(docxBodyPath)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	fw.Write(bytes.Repeat([]byte(" "), maxDOCXBodySize+1))
+	w.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a zip", []byte("plain text, not an archive")},
+		{"truncated archive", valid[:len(valid)/2]},
+		{"no document body", noBody.Bytes()},
+		{"body over the size limit", oversize.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if doc, err := Parse(FormatDOCX, tt.data); err == nil {
+				t.Errorf("Parse succeeded with %d blocks, want an error", len(doc.Blocks))
+			}
+		})
+	}
+}
+
+func TestRenderDOCX(t *testing.T) {
+	paragraphs := `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>Hi </w:t></w:r><w:r><w:t>team</w:t><w:br/><w:t>bye</w:t></w:r></w:p>` +
+		`<w:p><w:r><w:t>Keep &amp; me</w:t></w:r></w:p>`
+	doc, err := Parse(FormatDOCX, buildDOCX(t, paragraphs))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	doc.Blocks[0].Text = "Dear <colleagues>\nBest\tregards"
+	output, err := doc.Render()
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	body := readDOCXBody(t, output)
+
+	want := `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">Dear &lt;colleagues&gt;</w:t><w:br/>` +
+		`<w:t xml:space="preserve">Best</w:t><w:tab/><w:t xml:space="preserve">regards</w:t></w:r><w:r></w:r></w:p>` +
+		`<w:p><w:r><w:t>Keep &amp; me</w:t></w:r></w:p>`
+	if !strings.Contains(body, want) {
+		t.Errorf("rendered body:\n%s\nwant it to contain:\n%s", body, want)
+	}
+
+	// The rendered file parses back to the rewritten text
+	again, err := Parse(FormatDOCX, output)
+	if err != nil {
+		t.Fatalf("Parse rendered: %v", err)
+	}
+	if again.Blocks[0].Text != doc.Blocks[0].Text || again.Blocks[1].Text != "Keep & me" {
+		t.Errorf("rendered file parses to %+v", again.Blocks)
+	}
+}
@@ -0,0 +1,106 @@
+package document
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	headingPattern    = regexp.MustCompile(`^(#{1,6}[ \t]+)(.*)$`)
+	listItemPattern   = regexp.MustCompile(`^([ \t]*(?:[-*+]|\d{1,9}[.)])[ \t]+(?:\[[ xX]\][ \t]+)?)(.*)$`)
+	quotePattern      = regexp.MustCompile(`^([ \t]*>[ \t]?)(.*)$`)
+	rulePattern       = regexp.MustCompile(`^[ \t]*(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	continuationStart = regexp.MustCompile(`^[ \t]{2,}\S`)
+)
+
+// isFence reports whether line opens or closes a fenced code block
+func isFence(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+}
+
+// isVerbatim reports whether a line must be kept as is: tables, HTML and rules
+func isVerbatim(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, "<") || rulePattern.MatchString(trimmed)
+}
+
+// startsBlock reports whether line starts a new structural element
+func startsBlock(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+	return isFence(line) || isVerbatim(line) ||
+		headingPattern.MatchString(line) || listItemPattern.MatchString(line) || quotePattern.MatchString(line)
+}
+
+// parseMarkdown splits Markdown into headings, list items, quotes and paragraphs.
+// Markers stay in Prefix so only the prose is rewritten; code, tables and HTML are kept verbatim.
+func parseMarkdown(text string) []Block {
+	lines := strings.SplitAfter(text, "\n")
+	var blocks []Block
+
+	add := func(block Block) {
+		// Keep the line break that ended the block out of the rewritable text
+		if strings.HasSuffix(block.Text, "\n") {
+			block.Text = strings.TrimSuffix(block.Text, "\n")
+			block.Suffix = "\n" + block.Suffix
+		}
+		blocks = append(blocks, block)
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		content := strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(blocks) > 0 {
+				blocks[len(blocks)-1].Suffix += line
+			} else if line != "" {
+				blocks = append(blocks, Block{Suffix: line})
+			}
+			i++
+
+		case isFence(line):
+			code := line
+			for i++; i < len(lines); i++ {
+				code += lines[i]
+				if isFence(lines[i]) {
+					i++
+					break
+				}
+			}
+			add(Block{Text: code})
+
+		case isVerbatim(line):
+			add(Block{Text: line})
+			i++
+
+		case headingPattern.MatchString(content):
+			m := headingPattern.FindStringSubmatch(content)
+			add(Block{Prefix: m[1], Text: m[2] + line[len(m[0]):], Rewrite: true})
+			i++
+
+		case listItemPattern.MatchString(content) || quotePattern.MatchString(content):
+			pattern := listItemPattern
+			if !pattern.MatchString(content) {
+				pattern = quotePattern
+			}
+			m := pattern.FindStringSubmatch(content)
+			item := m[2] + line[len(m[0]):]
+			// Indented lines that follow belong to the same item
+			for i++; i < len(lines) && continuationStart.MatchString(lines[i]) && !startsBlock(lines[i]); i++ {
+				item += lines[i]
+			}
+			add(Block{Prefix: m[1], Text: item, Rewrite: true})
+
+		default:
+			paragraph := line
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]); i++ {
+				paragraph += lines[i]
+			}
+			add(Block{Text: paragraph, Rewrite: true})
+		}
+	}
+
+	return blocks
+}
@@ -0,0 +1,115 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestParseMarkdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Block
+	}{
+		{
+			name:  "heading keeps its marker",
+			input: "## Quarterly update\nWe shipped it.\n",
+			want: []Block{
+				{Prefix: "## ", Text: "Quarterly update", Suffix: "\n", Rewrite: true},
+				{Text: "We shipped it.", Suffix: "\n", Rewrite: true},
+			},
+		},
+		{
+			name:  "fenced code is kept whole, blank lines included",
+			input: "Intro\n\n```go\nfunc main() {\n\n\tfmt.Println(\"- not a list\")\n}\n```\nAfter\n",
+			want: []Block{
+				{Text: "Intro", Suffix: "\n\n", Rewrite: true},
+				{Text: "```go\nfunc main() {\n\n\tfmt.Println(\"- not a list\")\n}\n```", Suffix: "\n"},
+				{Text: "After", Suffix: "\n", Rewrite: true},
+			},
+		},
+		{
+			name:  "unclosed fence runs to the end",
+			input: "~~~\ncode\n# not a heading\n",
+			want: []Block{
+				{Text: "~~~\ncode\n# not a heading", Suffix: "\n"},
+			},
+		},
+		{
+			name:  "list items with continuation lines",
+			input: "- first item\n  wraps here\n- [x] done\n1. numbered\n",
+			want: []Block{
+				{Prefix: "- ", Text: "first item\n  wraps here", Suffix: "\n", Rewrite: true},
+				{Prefix: "- [x] ", Text: "done", Suffix: "\n", Rewrite: true},
+				{Prefix: "1. ", Text: "numbered", Suffix: "\n", Rewrite: true},
+			},
+		},
+		{
+			name:  "paragraph stops at a heading or list",
+			input: "Some text\n# Heading\nmore text\n* item\n",
+			want: []Block{
+				{Text: "Some text", Suffix: "\n", Rewrite: true},
+				{Prefix: "# ", Text: "Heading", Suffix: "\n", Rewrite: true},
+				{Text: "more text", Suffix: "\n", Rewrite: true},
+				{Prefix: "* ", Text: "item", Suffix: "\n", Rewrite: true},
+			},
+		},
+		{
+			name:  "quotes, tables, HTML and rules",
+			input: "> quoted\n| a | b |\n<br>\n---\n",
+			want: []Block{
+				{Prefix: "> ", Text: "quoted", Suffix: "\n", Rewrite: true},
+				{Text: "| a | b |", Suffix: "\n"},
+				{Text: "<br>", Suffix: "\n"},
+				{Text: "---", Suffix: "\n"},
+			},
+		},
+		{
+			name:  "Cyrillic",
+			input: "# Отчёт\n\n- Сделали всё\nЗадача закрыта.",
+			want: []Block{
+				{Prefix: "# ", Text: "Отчёт", Suffix: "\n\n", Rewrite: true},
+				{Prefix: "- ", Text: "Сделали всё", Suffix: "\n", Rewrite: true},
+				{Text: "Задача закрыта.", Rewrite: true},
+			},
+		},
+		{
+			name:  "leading blank lines",
+			input: "\n\nText",
+			want: []Block{
+				{Suffix: "\n\n"},
+				{Text: "Text", Rewrite: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(FormatMarkdown, []byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			assertBlocks(t, doc.Blocks, tt.want)
+
+			rendered, err := doc.Render()
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if string(rendered) != tt.input {
+				t.Errorf("Render = %q, want the input back", rendered)
+			}
+		})
+	}
+}
+
+// assertBlocks compares parsed blocks with the expected ones
+func assertBlocks(t *testing.T, got, want []Block) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks, want %d:\n got: %+v\nwant: %+v", len(got), len(want), got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
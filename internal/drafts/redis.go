@@ -0,0 +1,96 @@
+package drafts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// draftTTL is how long the rewrite buttons under a reply keep working
+const draftTTL = 48 * time.Hour
+
+// Store keeps the original drafts behind bot replies so they can be re-run
+type Store struct {
+	client *redis.Client
+}
+
+// Draft is the input that produced a bot reply
+type Draft struct {
+	TelegramID  int64  `json:"telegram_id"` // user who owns the draft
+	Text        string `json:"text"`
+	Style       string `json:"style"`
+	PhotoFileID string `json:"photo_file_id,omitempty"` // screenshot the reply was drafted for
+	Mode        string `json:"mode,omitempty"`          // claude.Mode of the request, empty for rewrites
+
+	// Message the reply was drafted for, when the draft was a reaction to one
+	ContextAuthor string `json:"context_author,omitempty"`
+	ContextText   string `json:"context_text,omitempty"`
+}
+
+// New creates a new Store instance
+func New(redisURL string) (*Store, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log.Println("Draft store connected to Redis")
+
+	return &Store{client: client}, nil
+}
+
+// Close closes the Redis connection
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+// getDraftKey generates a Redis key for the draft behind a bot message
+func (s *Store) getDraftKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("draft:%d:%d", chatID, messageID)
+}
+
+// Save stores the draft behind the bot message chatID/messageID
+func (s *Store) Save(ctx context.Context, chatID int64, messageID int, draft *Draft) error {
+	data, err := json.Marshal(draft)
+	if err != nil {
+		return fmt.Errorf("failed to marshal draft: %w", err)
+	}
+
+	if err := s.client.Set(ctx, s.getDraftKey(chatID, messageID), data, draftTTL).Err(); err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+
+	return nil
+}
+
+// Get returns the draft behind a bot message, or nil if it expired or never existed
+func (s *Store) Get(ctx context.Context, chatID int64, messageID int) (*Draft, error) {
+	data, err := s.client.Get(ctx, s.getDraftKey(chatID, messageID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draft: %w", err)
+	}
+
+	draft := &Draft{}
+	if err := json.Unmarshal(data, draft); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal draft: %w", err)
+	}
+
+	return draft, nil
+}
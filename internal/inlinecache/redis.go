@@ -0,0 +1,105 @@
+package inlinecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// resultTTL is how long a rewrite is reused for the same inline query text
+	resultTTL = 10 * time.Minute
+	// latestTTL only needs to outlive the debounce window; a query that waits longer
+	// is still processed, as no newer one is known
+	latestTTL = 30 * time.Second
+)
+
+// Cache debounces inline queries and caches their rewrites in Redis,
+// so several bot replicas share the same state
+type Cache struct {
+	client *redis.Client
+}
+
+// New creates a new Cache instance
+func New(redisURL string) (*Cache, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log.Println("Inline cache connected to Redis")
+
+	return &Cache{client: client}, nil
+}
+
+// Close closes the Redis connection
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
+
+// getLatestKey generates a Redis key for the newest inline query of a user
+func (c *Cache) getLatestKey(telegramID int64) string {
+	return fmt.Sprintf("inline:%d:latest", telegramID)
+}
+
+// getResultKey generates a Redis key for a cached rewrite of a user's query text in a style
+func (c *Cache) getResultKey(telegramID int64, style, text string) string {
+	sum := sha256.Sum256([]byte(style + "\x00" + text))
+	return fmt.Sprintf("inline:%d:result:%s", telegramID, hex.EncodeToString(sum[:]))
+}
+
+// MarkLatest records queryID as the newest inline query typed by the user
+func (c *Cache) MarkLatest(ctx context.Context, telegramID int64, queryID string) error {
+	if err := c.client.Set(ctx, c.getLatestKey(telegramID), queryID, latestTTL).Err(); err != nil {
+		return fmt.Errorf("failed to mark latest inline query: %w", err)
+	}
+	return nil
+}
+
+// IsLatest reports whether queryID is still the newest inline query of the user.
+// Once the mark has expired no newer query is known, so any query counts as the latest.
+func (c *Cache) IsLatest(ctx context.Context, telegramID int64, queryID string) (bool, error) {
+	latest, err := c.client.Get(ctx, c.getLatestKey(telegramID)).Result()
+	if err == redis.Nil {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get latest inline query: %w", err)
+	}
+	return latest == queryID, nil
+}
+
+// GetResult returns a cached rewrite, or "" if there is none
+func (c *Cache) GetResult(ctx context.Context, telegramID int64, style, text string) (string, error) {
+	result, err := c.client.Get(ctx, c.getResultKey(telegramID, style, text)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get cached inline result: %w", err)
+	}
+	return result, nil
+}
+
+// SetResult caches a rewrite for the user's query text in a style
+func (c *Cache) SetResult(ctx context.Context, telegramID int64, style, text, result string) error {
+	if err := c.client.Set(ctx, c.getResultKey(telegramID, style, text), result, resultTTL).Err(); err != nil {
+		return fmt.Errorf("failed to cache inline result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package invoice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// payloadVersion prefixes every signed payload so the format can change later
+const payloadVersion = "v1"
+
+// signatureSize is how many bytes of the HMAC are kept. Telegram limits invoice
+// payloads to 128 bytes, and 16 bytes are plenty against forgery.
+const signatureSize = 16
+
+var (
+	// ErrMalformed means the payload was not issued by this bot
+	ErrMalformed = errors.New("malformed invoice payload")
+	// ErrBadSignature means the payload was altered or signed with another secret
+	ErrBadSignature = errors.New("invalid invoice signature")
+	// ErrExpired means the invoice is older than the signer's TTL
+	ErrExpired = errors.New("invoice expired")
+)
+
+// Payload is what an invoice was issued for
+type Payload struct {
+	PlanID     string
+	TelegramID int64 // user the invoice was issued to
+	Price      int   // in Stars
+	IssuedAt   time.Time
+}
+
+// Signer signs invoice payloads and verifies them at pre-checkout
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSigner creates a Signer. Invoices older than ttl are rejected.
+func NewSigner(secret string, ttl time.Duration) *Signer {
+	return &Signer{secret: []byte(secret), ttl: ttl}
+}
+
+// Sign encodes p as "v1:plan:user:price:issued:signature"
+func (s *Signer) Sign(p Payload) string {
+	body := strings.Join([]string{
+		payloadVersion,
+		p.PlanID,
+		strconv.FormatInt(p.TelegramID, 10),
+		strconv.Itoa(p.Price),
+		strconv.FormatInt(p.IssuedAt.Unix(), 10),
+	}, ":")
+	return body + ":" + s.signature(body)
+}
+
+// Verify decodes a payload produced by Sign, checking its signature and age
+func (s *Signer) Verify(raw string, now time.Time) (*Payload, error) {
+	i := strings.LastIndexByte(raw, ':')
+	if i < 0 {
+		return nil, ErrMalformed
+	}
+	body, signature := raw[:i], raw[i+1:]
+
+	fields := strings.Split(body, ":")
+	if len(fields) != 5 || fields[0] != payloadVersion {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(body))) {
+		return nil, ErrBadSignature
+	}
+
+	telegramID, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: user ID: %v", ErrMalformed, err)
+	}
+	price, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("%w: price: %v", ErrMalformed, err)
+	}
+	issued, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: issue time: %v", ErrMalformed, err)
+	}
+
+	p := &Payload{
+		PlanID:     fields[1],
+		TelegramID: telegramID,
+		Price:      price,
+		IssuedAt:   time.Unix(issued, 0),
+	}
+	if now.Sub(p.IssuedAt) > s.ttl {
+		return p, ErrExpired
+	}
+
+	return p, nil
+}
+
+// signature is the truncated, URL-safe HMAC-SHA256 of body
+func (s *Signer) signature(body string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureSize])
+}
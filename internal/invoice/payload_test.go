@@ -0,0 +1,95 @@
+package invoice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const ttl = time.Hour
+	signer := NewSigner("secret", ttl)
+	issued := time.Unix(1_700_000_000, 0)
+	valid := signer.Sign(Payload{PlanID: "monthly", TelegramID: 42, Price: 250, IssuedAt: issued})
+
+	// signed builds a payload with a valid signature over an arbitrary body
+	signed := func(fields ...string) string {
+		body := strings.Join(fields, ":")
+		return body + ":" + signer.signature(body)
+	}
+	// replaceField swaps one colon-separated field of the valid payload
+	replaceField := func(i int, value string) string {
+		fields := strings.Split(valid, ":")
+		fields[i] = value
+		return strings.Join(fields, ":")
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		signer  *Signer
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid round trip", raw: valid, now: issued.Add(time.Minute)},
+		{name: "valid at the end of the TTL", raw: valid, now: issued.Add(ttl)},
+		{name: "expired", raw: valid, now: issued.Add(ttl + time.Second), wantErr: ErrExpired},
+		{name: "tampered plan", raw: replaceField(1, "yearly"), wantErr: ErrBadSignature},
+		{name: "tampered user", raw: replaceField(2, "43"), wantErr: ErrBadSignature},
+		{name: "tampered price", raw: replaceField(3, "1"), wantErr: ErrBadSignature},
+		{name: "tampered issue time", raw: replaceField(4, "1800000000"), wantErr: ErrBadSignature},
+		{name: "truncated signature", raw: valid[:len(valid)-4], wantErr: ErrBadSignature},
+		{name: "forged signature", raw: replaceField(5, "AAAAAAAAAAAAAAAAAAAAAA"), wantErr: ErrBadSignature},
+		{name: "empty signature", raw: replaceField(5, ""), wantErr: ErrBadSignature},
+		{name: "wrong secret", raw: valid, signer: NewSigner("other secret", ttl), wantErr: ErrBadSignature},
+		{name: "wrong version", raw: replaceField(0, "v2"), wantErr: ErrMalformed},
+		{name: "too few fields", raw: signed("v1", "monthly", "42", "250"), wantErr: ErrMalformed},
+		{name: "too many fields", raw: signed("v1", "monthly", "42", "250", "1700000000", "extra"), wantErr: ErrMalformed},
+		{name: "no separator", raw: "garbage", wantErr: ErrMalformed},
+		{name: "empty", raw: "", wantErr: ErrMalformed},
+		{name: "non-numeric user", raw: signed("v1", "monthly", "abc", "250", "1700000000"), wantErr: ErrMalformed},
+		{name: "non-numeric price", raw: signed("v1", "monthly", "42", "2.5", "1700000000"), wantErr: ErrMalformed},
+		{name: "non-numeric issue time", raw: signed("v1", "monthly", "42", "250", "yesterday"), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := signer
+			if tt.signer != nil {
+				s = tt.signer
+			}
+			now := tt.now
+			if now.IsZero() {
+				now = issued
+			}
+
+			p, err := s.Verify(tt.raw, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify(%q) error = %v, want %v", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify(%q): %v", tt.raw, err)
+			}
+			want := Payload{PlanID: "monthly", TelegramID: 42, Price: 250, IssuedAt: issued}
+			if *p != want {
+				t.Errorf("Verify(%q) = %+v, want %+v", tt.raw, *p, want)
+			}
+		})
+	}
+}
+
+func TestSignFitsTelegramPayloadLimit(t *testing.T) {
+	signer := NewSigner("secret", time.Hour)
+	raw := signer.Sign(Payload{
+		PlanID:     strings.Repeat("p", 50),
+		TelegramID: -1 << 62,
+		Price:      1 << 30,
+		IssuedAt:   time.Unix(1<<40, 0),
+	})
+	if len(raw) > 128 {
+		t.Errorf("payload is %d bytes, over Telegram's 128: %q", len(raw), raw)
+	}
+}
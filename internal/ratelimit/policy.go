@@ -0,0 +1,303 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Rule identifies one limit of a Policy
+type Rule string
+
+const (
+	RuleMessageLength     Rule = "message_length"
+	RuleConcurrent        Rule = "concurrent"
+	RuleRequestsPerMinute Rule = "requests_per_minute"
+	RuleDailyTokens       Rule = "daily_tokens"
+)
+
+// Policy combines the limits applied to every request. A zero value disables a rule.
+type Policy struct {
+	DailyTokens       int // free tokens per user per local day
+	RequestsPerMinute int // requests per user in any sliding 60 second window
+	MaxMessageLength  int // characters per message
+	MaxConcurrent     int // requests per user being processed at the same time
+}
+
+// Request describes what a rewrite is about to consume
+type Request struct {
+	TelegramID    int64
+	Location      *time.Location // the user's timezone for the daily budget
+	Tokens        int            // estimate reserved from the daily budget; 0 when billed elsewhere
+	MessageLength int            // characters in the user's message
+	Hold          time.Duration  // how long the in-flight slot may be held; 0 for the default of a single rewrite
+	// Continuation marks a further part of a job, e.g. a document chunk, whose own reservation
+	// still holds the in-flight slot: only the daily token rule applies, and no slot is taken
+	Continuation bool
+	// Uncounted checks the requests-per-minute rule without counting the request, e.g. the
+	// transcription of a voice message whose rewrite is counted right after it
+	Uncounted bool
+}
+
+// Denial explains which rule rejected a request and when it clears
+type Denial struct {
+	Rule       Rule
+	Limit      int
+	Current    int           // usage counted against the limit when the request was denied
+	Remaining  int           // daily tokens left
+	RetryAfter time.Duration // 0 if it can't be known, e.g. until another request finishes
+}
+
+// Reservation is a hold taken by CheckAndReserve: daily tokens and an in-flight slot.
+// It must be released with Settle or Refund; releasing it twice has no effect.
+type Reservation struct {
+	ID         string
+	TelegramID int64
+	Tokens     int
+	Remaining  int    // daily tokens left after the reservation
+	tokenKey   string // the day the tokens were reserved on, even if settled after midnight
+}
+
+const (
+	// window is the length of the requests-per-minute sliding window
+	window = time.Minute
+	// reservationTTL bounds how long an unreleased reservation can be settled.
+	// If a request never releases it, the reserved tokens simply stay charged.
+	reservationTTL = time.Hour
+	// inFlightTTL frees a concurrency slot whose request never released it
+	inFlightTTL = 3 * time.Minute
+)
+
+// denialRules maps the rule codes returned by reserveScript
+var denialRules = map[int64]Rule{
+	1: RuleConcurrent,
+	2: RuleRequestsPerMinute,
+	3: RuleDailyTokens,
+}
+
+// reserveScript evaluates every Redis-backed rule and takes the reservation only if all pass.
+// KEYS[1] = token usage key, KEYS[2] = reservation key, KEYS[3] = window key, KEYS[4] = in-flight key
+// ARGV[1] = now (ms), ARGV[2] = reservation ID, ARGV[3] = tokens to reserve,
+// ARGV[4] = daily limit, ARGV[5] = requests per minute, ARGV[6] = max concurrent,
+// ARGV[7] = window (ms), ARGV[8] = usage TTL (s), ARGV[9] = reservation TTL (s), ARGV[10] = in-flight hold (ms),
+// ARGV[11] = continuation (0/1), ARGV[12] = uncounted (0/1)
+// Returns {rule code (0 = allowed), current, remaining daily tokens, retry after (ms)}
+var reserveScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = tonumber(ARGV[3])
+local dailyLimit = tonumber(ARGV[4])
+local perMinute = tonumber(ARGV[5])
+local maxConcurrent = tonumber(ARGV[6])
+local windowMs = tonumber(ARGV[7])
+local hold = tonumber(ARGV[10])
+local continuation = ARGV[11] == '1'
+local counted = ARGV[12] ~= '1'
+
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local remaining = math.max(dailyLimit - used, 0)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[4], '-inf', now)
+if maxConcurrent > 0 and not continuation then
+	local inFlight = redis.call('ZCARD', KEYS[4])
+	if inFlight >= maxConcurrent then
+		return {1, inFlight, remaining, 0}
+	end
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', now - windowMs)
+if perMinute > 0 and not continuation then
+	local recent = redis.call('ZCARD', KEYS[3])
+	if recent >= perMinute then
+		local oldest = redis.call('ZRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+		return {2, recent, remaining, tonumber(oldest[2]) + windowMs - now}
+	end
+end
+
+if dailyLimit > 0 and tokens > 0 and used + tokens > dailyLimit then
+	return {3, used, remaining, -1}
+end
+
+used = redis.call('INCRBY', KEYS[1], tokens)
+redis.call('EXPIRE', KEYS[1], ARGV[8])
+redis.call('SET', KEYS[2], tokens, 'EX', ARGV[9])
+if continuation then
+	return {0, used, math.max(dailyLimit - used, 0), 0}
+end
+if counted then
+	redis.call('ZADD', KEYS[3], now, ARGV[2])
+	redis.call('PEXPIRE', KEYS[3], windowMs)
+end
+redis.call('ZADD', KEYS[4], now + hold, ARGV[2])
+-- Never cut short the slot of a longer job already in the set
+if redis.call('PTTL', KEYS[4]) < hold then
+	redis.call('PEXPIRE', KEYS[4], hold)
+end
+return {0, used, math.max(dailyLimit - used, 0), 0}
+`)
+
+// releaseScript replaces a reservation with the actual usage, or refunds it when actual is 0,
+// and frees its in-flight slot.
+// KEYS[1] = token usage key, KEYS[2] = reservation key, KEYS[3] = in-flight key
+// ARGV[1] = daily limit, ARGV[2] = actual tokens, ARGV[3] = usage TTL (s), ARGV[4] = reservation ID
+// Returns {released (0/1), remaining tokens}
+var releaseScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[4])
+local reserved = redis.call('GET', KEYS[2])
+if not reserved then
+	local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+	return {0, math.max(limit - used, 0)}
+end
+redis.call('DEL', KEYS[2])
+local used = redis.call('INCRBY', KEYS[1], tonumber(ARGV[2]) - tonumber(reserved))
+if used < 0 then
+	redis.call('SET', KEYS[1], 0)
+	used = 0
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return {1, math.max(limit - used, 0)}
+`)
+
+// newReservationID returns a random reservation ID
+func newReservationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate reservation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CheckMessageLength applies only the message length rule, so oversized input can be
+// rejected before the request is estimated or anything is reserved
+func (l *Limiter) CheckMessageLength(length int) *Denial {
+	if l.policy.MaxMessageLength > 0 && length > l.policy.MaxMessageLength {
+		return &Denial{
+			Rule:    RuleMessageLength,
+			Limit:   l.policy.MaxMessageLength,
+			Current: length,
+		}
+	}
+	return nil
+}
+
+// CheckAndReserve evaluates all rules of the policy in one Redis round trip and,
+// if none is hit, reserves the request's tokens and an in-flight slot atomically,
+// so concurrent requests can't overshoot any limit.
+// Returns: (reservation, denial, error). Exactly one of reservation and denial is set on success.
+func (l *Limiter) CheckAndReserve(ctx context.Context, req Request) (*Reservation, *Denial, error) {
+	// Message length needs no shared state, so reject it before touching Redis
+	if denial := l.CheckMessageLength(req.MessageLength); denial != nil {
+		return nil, denial, nil
+	}
+
+	id, err := newReservationID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokenKey := l.getTokenKey(req.TelegramID, req.Location)
+	keys := []string{
+		tokenKey,
+		l.getReservationKey(req.TelegramID, id),
+		l.getWindowKey(req.TelegramID),
+		l.getInFlightKey(req.TelegramID),
+	}
+
+	// A long job, e.g. a document, keeps its slot until it is done, so it keeps counting
+	// against the concurrency cap and blocks moving the counters to another timezone
+	hold, keep := inFlightTTL, reservationTTL
+	if req.Hold > hold {
+		hold = req.Hold
+	}
+	if hold > keep {
+		keep = hold
+	}
+
+	continuation, uncounted := 0, 0
+	if req.Continuation {
+		continuation = 1
+	}
+	if req.Uncounted {
+		uncounted = 1
+	}
+
+	result, err := reserveScript.Run(ctx, l.client, keys,
+		time.Now().UnixMilli(), id, req.Tokens,
+		l.policy.DailyTokens, l.policy.RequestsPerMinute, l.policy.MaxConcurrent,
+		window.Milliseconds(), int(usageTTL.Seconds()), int(keep.Seconds()), hold.Milliseconds(), continuation, uncounted,
+	).Int64Slice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to reserve tokens: %w", err)
+	}
+
+	code, current, remaining, retryMs := result[0], int(result[1]), int(result[2]), result[3]
+	if code != 0 {
+		rule := denialRules[code]
+		denial := &Denial{
+			Rule:       rule,
+			Current:    current,
+			Remaining:  remaining,
+			RetryAfter: time.Duration(retryMs) * time.Millisecond,
+		}
+		switch rule {
+		case RuleConcurrent:
+			denial.Limit = l.policy.MaxConcurrent
+		case RuleRequestsPerMinute:
+			denial.Limit = l.policy.RequestsPerMinute
+		case RuleDailyTokens:
+			denial.Limit = l.policy.DailyTokens
+			denial.RetryAfter = l.GetTimeUntilReset(req.Location)
+		}
+		return nil, denial, nil
+	}
+
+	return &Reservation{
+		ID:         id,
+		TelegramID: req.TelegramID,
+		Tokens:     req.Tokens,
+		Remaining:  remaining,
+		tokenKey:   tokenKey,
+	}, nil, nil
+}
+
+// Settle replaces the reserved estimate with the actual daily token usage.
+// Returns the remaining tokens for the day the reservation was made.
+func (l *Limiter) Settle(ctx context.Context, r *Reservation, actualTokens int) (int, error) {
+	remaining, err := l.release(ctx, r, actualTokens)
+	if err != nil {
+		return 0, fmt.Errorf("failed to settle token usage: %w", err)
+	}
+	return remaining, nil
+}
+
+// Refund gives the reserved tokens back after a failed request
+func (l *Limiter) Refund(ctx context.Context, r *Reservation) (int, error) {
+	remaining, err := l.release(ctx, r, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refund tokens: %w", err)
+	}
+	return remaining, nil
+}
+
+// release runs releaseScript for a reservation
+func (l *Limiter) release(ctx context.Context, r *Reservation, actualTokens int) (int, error) {
+	keys := []string{r.tokenKey, l.getReservationKey(r.TelegramID, r.ID), l.getInFlightKey(r.TelegramID)}
+
+	result, err := releaseScript.Run(ctx, l.client, keys,
+		l.policy.DailyTokens, actualTokens, int(usageTTL.Seconds()), r.ID,
+	).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+
+	if result[0] == 0 {
+		log.Printf("Reservation %s of user %d was already released or expired", r.ID, r.TelegramID)
+	}
+
+	return int(result[1]), nil
+}
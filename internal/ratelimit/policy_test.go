@@ -0,0 +1,291 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLimiter connects to the Redis in TEST_REDIS_URL and returns a limiter enforcing
+// policy, plus a user ID no other test run shares. The test is skipped without Redis.
+func newTestLimiter(t *testing.T, policy Policy) (*Limiter, int64) {
+	t.Helper()
+
+	redisURL := os.Getenv("TEST_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+
+	limiter, err := New(redisURL, policy)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	telegramID := -1 - rand.Int63n(1<<40)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, err := limiter.client.Keys(ctx, fmt.Sprintf("user:%d:*", telegramID)).Result()
+		if err == nil && len(keys) > 0 {
+			limiter.client.Del(ctx, keys...)
+		}
+		limiter.Close()
+	})
+
+	return limiter, telegramID
+}
+
+// reserveConcurrently calls CheckAndReserve from n goroutines at once and returns the
+// reservations that were granted
+func reserveConcurrently(t *testing.T, limiter *Limiter, n int, req Request) []*Reservation {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		granted []*Reservation
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			reservation, denial, err := limiter.CheckAndReserve(context.Background(), req)
+			if err != nil {
+				t.Errorf("CheckAndReserve: %v", err)
+				return
+			}
+			if (reservation == nil) == (denial == nil) {
+				t.Errorf("CheckAndReserve returned reservation %v and denial %v", reservation, denial)
+				return
+			}
+			if reservation != nil {
+				mu.Lock()
+				granted = append(granted, reservation)
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	return granted
+}
+
+// usedTokens reads today's committed token counter of a user
+func usedTokens(t *testing.T, limiter *Limiter, telegramID int64) int {
+	t.Helper()
+
+	_, used, _, err := limiter.GetUsage(context.Background(), telegramID, time.UTC)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	return used
+}
+
+func TestCheckAndReserveParallelRequestsStayWithinDailyLimit(t *testing.T) {
+	const (
+		dailyTokens = 1000
+		perRequest  = 70
+		requests    = 64
+	)
+	limiter, telegramID := newTestLimiter(t, Policy{DailyTokens: dailyTokens})
+	req := Request{TelegramID: telegramID, Location: time.UTC, Tokens: perRequest, MessageLength: 10}
+
+	granted := reserveConcurrently(t, limiter, requests, req)
+
+	if want := dailyTokens / perRequest; len(granted) != want {
+		t.Errorf("granted %d reservations, want %d", len(granted), want)
+	}
+	if used := usedTokens(t, limiter, telegramID); used > dailyTokens {
+		t.Fatalf("reserved %d tokens, over the daily limit of %d", used, dailyTokens)
+	}
+
+	// Settling in parallel, some above the estimate, must still add up exactly
+	var wg sync.WaitGroup
+	want := 0
+	for i, reservation := range granted {
+		actual := perRequest - 10 + i%3*10
+		want += actual
+		wg.Add(1)
+		go func(r *Reservation, actual int) {
+			defer wg.Done()
+			if _, err := limiter.Settle(context.Background(), r, actual); err != nil {
+				t.Errorf("Settle: %v", err)
+			}
+		}(reservation, actual)
+	}
+	wg.Wait()
+
+	if used := usedTokens(t, limiter, telegramID); used != want {
+		t.Errorf("committed %d tokens after settling, want %d", used, want)
+	}
+
+	// Settling below the estimates freed some budget; a second wave may only use that
+	reserveConcurrently(t, limiter, requests, req)
+	if used := usedTokens(t, limiter, telegramID); used > dailyTokens {
+		t.Errorf("committed %d tokens after a second wave, over the daily limit of %d", used, dailyTokens)
+	}
+}
+
+func TestCheckAndReserveParallelRequestsStayWithinInFlightCap(t *testing.T) {
+	const (
+		maxConcurrent = 3
+		requests      = 32
+	)
+	limiter, telegramID := newTestLimiter(t, Policy{MaxConcurrent: maxConcurrent})
+	req := Request{TelegramID: telegramID, Location: time.UTC, MessageLength: 10}
+
+	// Nothing is released, so only the cap decides how many get through
+	granted := reserveConcurrently(t, limiter, requests, req)
+	if len(granted) != maxConcurrent {
+		t.Fatalf("granted %d reservations at once, want %d", len(granted), maxConcurrent)
+	}
+
+	// Releasing one slot admits exactly one more
+	if _, err := limiter.Refund(context.Background(), granted[0]); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if again := reserveConcurrently(t, limiter, requests, req); len(again) != 1 {
+		t.Errorf("granted %d reservations after freeing one slot, want 1", len(again))
+	}
+}
+
+func TestMoveToTimezoneRefusedWhileReserved(t *testing.T) {
+	limiter, telegramID := newTestLimiter(t, Policy{DailyTokens: 1000})
+	ctx := context.Background()
+	// 26 hours apart, so the two zones are never on the same date
+	east, west := time.FixedZone("UTC+14", 14*3600), time.FixedZone("UTC-12", -12*3600)
+
+	reservation, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: east, Tokens: 100, MessageLength: 10})
+	if err != nil || reservation == nil {
+		t.Fatalf("CheckAndReserve: %v, %v", reservation, err)
+	}
+
+	if err := limiter.MoveToTimezone(ctx, telegramID, east, west); !errors.Is(err, ErrRequestsInFlight) {
+		t.Fatalf("MoveToTimezone with a reservation in flight: got %v, want ErrRequestsInFlight", err)
+	}
+
+	if _, err := limiter.Settle(ctx, reservation, 40); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+	if err := limiter.MoveToTimezone(ctx, telegramID, east, west); err != nil {
+		t.Fatalf("MoveToTimezone: %v", err)
+	}
+
+	_, used, _, err := limiter.GetUsage(ctx, telegramID, west)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if used != 40 {
+		t.Errorf("moved %d tokens, want the settled 40", used)
+	}
+}
+
+func TestLongHoldKeepsTimezoneMoveRefused(t *testing.T) {
+	limiter, telegramID := newTestLimiter(t, Policy{DailyTokens: 1000, MaxConcurrent: 5})
+	ctx := context.Background()
+	east, west := time.FixedZone("UTC+14", 14*3600), time.FixedZone("UTC-12", -12*3600)
+	const hold = 40 * time.Minute
+
+	document, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: east, Tokens: 500, Hold: hold})
+	if err != nil || document == nil {
+		t.Fatalf("CheckAndReserve: %v, %v", document, err)
+	}
+
+	// A short request that comes and goes must not shorten the document's slot
+	short, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: east, Tokens: 10, MessageLength: 10})
+	if err != nil || short == nil {
+		t.Fatalf("CheckAndReserve: %v, %v", short, err)
+	}
+	if _, err := limiter.Settle(ctx, short, 10); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+
+	key := limiter.getInFlightKey(telegramID)
+	if ttl := limiter.client.PTTL(ctx, key).Val(); ttl < hold-time.Minute {
+		t.Errorf("in-flight set expires in %s, want about %s", ttl, hold)
+	}
+	score, err := limiter.client.ZScore(ctx, key, document.ID).Result()
+	if err != nil {
+		t.Fatalf("ZScore: %v", err)
+	}
+	if until := time.UnixMilli(int64(score)); time.Until(until) < hold-time.Minute {
+		t.Errorf("document slot is held until %s, want about %s from now", until, hold)
+	}
+
+	if err := limiter.MoveToTimezone(ctx, telegramID, east, west); !errors.Is(err, ErrRequestsInFlight) {
+		t.Errorf("MoveToTimezone during a document: got %v, want ErrRequestsInFlight", err)
+	}
+}
+
+func TestContinuationOnlyChecksDailyTokens(t *testing.T) {
+	limiter, telegramID := newTestLimiter(t, Policy{DailyTokens: 100, RequestsPerMinute: 1, MaxConcurrent: 1})
+	ctx := context.Background()
+
+	// The job's own reservation uses up both the per-minute window and the in-flight cap
+	job, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: time.UTC})
+	if err != nil || job == nil {
+		t.Fatalf("CheckAndReserve: %v, %v", job, err)
+	}
+
+	part := Request{TelegramID: telegramID, Location: time.UTC, Tokens: 60, Continuation: true}
+	first, denial, err := limiter.CheckAndReserve(ctx, part)
+	if err != nil || first == nil {
+		t.Fatalf("first part: %v, %v, %v", first, denial, err)
+	}
+	if _, err := limiter.Settle(ctx, first, 60); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+
+	// The next part no longer fits the daily budget
+	second, denial, err := limiter.CheckAndReserve(ctx, part)
+	if err != nil {
+		t.Fatalf("second part: %v", err)
+	}
+	if second != nil || denial == nil || denial.Rule != RuleDailyTokens {
+		t.Fatalf("second part: got %v, %+v, want a daily token denial", second, denial)
+	}
+
+	// Parts take no slot of their own, so the job alone still holds the in-flight set
+	if n := limiter.client.ZCard(ctx, limiter.getInFlightKey(telegramID)).Val(); n != 1 {
+		t.Errorf("in-flight set has %d entries, want only the job", n)
+	}
+	if _, err := limiter.Refund(ctx, job); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+}
+
+func TestUncountedRequestIsCheckedButNotCounted(t *testing.T) {
+	limiter, telegramID := newTestLimiter(t, Policy{RequestsPerMinute: 1})
+	ctx := context.Background()
+
+	transcription, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: time.UTC, Tokens: 10, Uncounted: true})
+	if err != nil || transcription == nil {
+		t.Fatalf("uncounted request: %v, %v", transcription, err)
+	}
+	if _, err := limiter.Settle(ctx, transcription, 10); err != nil {
+		t.Fatalf("Settle: %v", err)
+	}
+
+	// The request that follows still fits the window of one
+	rewrite, _, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: time.UTC, MessageLength: 10})
+	if err != nil || rewrite == nil {
+		t.Fatalf("counted request after an uncounted one: %v, %v", rewrite, err)
+	}
+
+	// With the window full, an uncounted request is refused like any other
+	_, denial, err := limiter.CheckAndReserve(ctx, Request{TelegramID: telegramID, Location: time.UTC, Uncounted: true})
+	if err != nil {
+		t.Fatalf("CheckAndReserve: %v", err)
+	}
+	if denial == nil || denial.Rule != RuleRequestsPerMinute {
+		t.Errorf("uncounted request with a full window: got %+v, want a requests-per-minute denial", denial)
+	}
+}
@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -11,12 +12,12 @@ import (
 
 // Limiter handles rate limiting using Redis
 type Limiter struct {
-	client     *redis.Client
-	dailyLimit int
+	client *redis.Client
+	policy Policy
 }
 
-// New creates a new Limiter instance
-func New(redisURL string, dailyLimit int) (*Limiter, error) {
+// New creates a new Limiter instance enforcing policy
+func New(redisURL string, policy Policy) (*Limiter, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
@@ -35,8 +36,8 @@ func New(redisURL string, dailyLimit int) (*Limiter, error) {
 	log.Println("Successfully connected to Redis")
 
 	return &Limiter{
-		client:     client,
-		dailyLimit: dailyLimit,
+		client: client,
+		policy: policy,
 	}, nil
 }
 
@@ -45,93 +46,61 @@ func (l *Limiter) Close() error {
 	return l.client.Close()
 }
 
-// getDateKey generates a Redis key for the current date
-func (l *Limiter) getDateKey() string {
-	return time.Now().Format("2006-01-02")
+// getDateKey generates a Redis key for the current date in the user's timezone
+func (l *Limiter) getDateKey(loc *time.Location) string {
+	return time.Now().In(loc).Format("2006-01-02")
 }
 
 // getTokenKey generates a Redis key for token usage
-func (l *Limiter) getTokenKey(telegramID int64) string {
-	return fmt.Sprintf("user:%d:tokens:%s", telegramID, l.getDateKey())
+func (l *Limiter) getTokenKey(telegramID int64, loc *time.Location) string {
+	return fmt.Sprintf("user:%d:tokens:%s", telegramID, l.getDateKey(loc))
 }
 
-// getRequestKey generates a Redis key for request count
-func (l *Limiter) getRequestKey(telegramID int64) string {
-	return fmt.Sprintf("user:%d:requests:%s", telegramID, l.getDateKey())
-}
-
-// CheckAndReserve checks if user can make a request and reserves tokens
-// Returns: (allowed, remaining tokens, error)
-func (l *Limiter) CheckAndReserve(ctx context.Context, telegramID int64, estimatedTokens int) (bool, int, error) {
-	tokenKey := l.getTokenKey(telegramID)
-
-	// Get current usage
-	currentTokens, err := l.client.Get(ctx, tokenKey).Int()
-	if err != nil && err != redis.Nil {
-		return false, 0, fmt.Errorf("failed to get token usage: %w", err)
-	}
-
-	// Check if adding estimated tokens would exceed limit
-	if currentTokens+estimatedTokens > l.dailyLimit {
-		remaining := l.dailyLimit - currentTokens
-		if remaining < 0 {
-			remaining = 0
-		}
-		return false, remaining, nil
-	}
-
-	// Reserve tokens
-	newTotal, err := l.client.IncrBy(ctx, tokenKey, int64(estimatedTokens)).Result()
-	if err != nil {
-		return false, 0, fmt.Errorf("failed to reserve tokens: %w", err)
-	}
-
-	// Set expiration to 48 hours (to keep for next day too)
-	l.client.Expire(ctx, tokenKey, 48*time.Hour)
+// usageTTL keeps yesterday's counters around for the next day too
+const usageTTL = 48 * time.Hour
 
-	remaining := l.dailyLimit - int(newTotal)
-	if remaining < 0 {
-		remaining = 0
-	}
-
-	return true, remaining, nil
+// getReservationKey generates a Redis key for a pending reservation
+func (l *Limiter) getReservationKey(telegramID int64, reservationID string) string {
+	return fmt.Sprintf("user:%d:reservation:%s", telegramID, reservationID)
 }
 
-// AdjustUsage adjusts the token usage (positive or negative adjustment)
-func (l *Limiter) AdjustUsage(ctx context.Context, telegramID int64, adjustment int) error {
-	tokenKey := l.getTokenKey(telegramID)
-
-	_, err := l.client.IncrBy(ctx, tokenKey, int64(adjustment)).Result()
-	if err != nil {
-		return fmt.Errorf("failed to adjust token usage: %w", err)
-	}
+// getWindowKey generates a Redis key for the sliding window of recent requests
+func (l *Limiter) getWindowKey(telegramID int64) string {
+	return fmt.Sprintf("user:%d:window", telegramID)
+}
 
-	// Ensure expiration is set
-	l.client.Expire(ctx, tokenKey, 48*time.Hour)
+// getInFlightKey generates a Redis key for the set of requests being processed
+func (l *Limiter) getInFlightKey(telegramID int64) string {
+	return fmt.Sprintf("user:%d:inflight", telegramID)
+}
 
-	return nil
+// getRequestKey generates a Redis key for request count
+func (l *Limiter) getRequestKey(telegramID int64, loc *time.Location) string {
+	return fmt.Sprintf("user:%d:requests:%s", telegramID, l.getDateKey(loc))
 }
 
 // IncrementRequests increments the request counter
-func (l *Limiter) IncrementRequests(ctx context.Context, telegramID int64) error {
-	requestKey := l.getRequestKey(telegramID)
-
-	_, err := l.client.Incr(ctx, requestKey).Result()
+func (l *Limiter) IncrementRequests(ctx context.Context, telegramID int64, loc *time.Location) error {
+	requestKey := l.getRequestKey(telegramID, loc)
+
+	// MULTI/EXEC so the expiration can't be lost between the two commands
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, requestKey)
+		pipe.Expire(ctx, requestKey, usageTTL)
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to increment request count: %w", err)
 	}
 
-	// Set expiration to 48 hours
-	l.client.Expire(ctx, requestKey, 48*time.Hour)
-
 	return nil
 }
 
 // GetUsage retrieves current usage statistics
 // Returns: (request count, tokens used, remaining tokens)
-func (l *Limiter) GetUsage(ctx context.Context, telegramID int64) (int, int, int, error) {
-	tokenKey := l.getTokenKey(telegramID)
-	requestKey := l.getRequestKey(telegramID)
+func (l *Limiter) GetUsage(ctx context.Context, telegramID int64, loc *time.Location) (int, int, int, error) {
+	tokenKey := l.getTokenKey(telegramID, loc)
+	requestKey := l.getRequestKey(telegramID, loc)
 
 	// Get tokens used
 	tokensUsed, err := l.client.Get(ctx, tokenKey).Int()
@@ -145,7 +114,7 @@ func (l *Limiter) GetUsage(ctx context.Context, telegramID int64) (int, int, int
 		return 0, 0, 0, fmt.Errorf("failed to get request count: %w", err)
 	}
 
-	remaining := l.dailyLimit - tokensUsed
+	remaining := l.policy.DailyTokens - tokensUsed
 	if remaining < 0 {
 		remaining = 0
 	}
@@ -154,9 +123,9 @@ func (l *Limiter) GetUsage(ctx context.Context, telegramID int64) (int, int, int
 }
 
 // ResetUserUsage resets usage for a specific user (for testing/admin purposes)
-func (l *Limiter) ResetUserUsage(ctx context.Context, telegramID int64) error {
-	tokenKey := l.getTokenKey(telegramID)
-	requestKey := l.getRequestKey(telegramID)
+func (l *Limiter) ResetUserUsage(ctx context.Context, telegramID int64, loc *time.Location) error {
+	tokenKey := l.getTokenKey(telegramID, loc)
+	requestKey := l.getRequestKey(telegramID, loc)
 
 	pipe := l.client.Pipeline()
 	pipe.Del(ctx, tokenKey)
@@ -171,9 +140,57 @@ func (l *Limiter) ResetUserUsage(ctx context.Context, telegramID int64) error {
 	return nil
 }
 
-// GetTimeUntilReset returns duration until midnight (reset time)
-func (l *Limiter) GetTimeUntilReset() time.Duration {
-	now := time.Now()
-	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+// GetTimeUntilReset returns duration until midnight (reset time) in the user's timezone
+func (l *Limiter) GetTimeUntilReset(loc *time.Location) time.Duration {
+	now := time.Now().In(loc)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
 	return tomorrow.Sub(now)
 }
+
+// ErrRequestsInFlight is returned by MoveToTimezone while a request holds a reservation
+// on the current day's counter
+var ErrRequestsInFlight = errors.New("requests in flight")
+
+// moveScript carries today's counters over to the keys of another day, unless a request
+// is in flight: its reservation would be settled against the old key.
+// KEYS[1] = in-flight key, then pairs of old key and new key; ARGV[1] = now (ms), ARGV[2] = TTL (seconds)
+// Returns 1 if nothing was moved because of requests in flight, 0 otherwise
+var moveScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) > 0 then
+	return 1
+end
+for i = 2, #KEYS, 2 do
+	local value = redis.call('GET', KEYS[i])
+	redis.call('DEL', KEYS[i + 1])
+	if value then
+		redis.call('SET', KEYS[i + 1], value, 'EX', ARGV[2])
+		redis.call('DEL', KEYS[i])
+	end
+end
+return 0
+`)
+
+// MoveToTimezone moves today's usage to the date keys of a new timezone, so changing
+// zones neither resets the quota nor picks up another day's counters.
+// Returns ErrRequestsInFlight if a request of the user is still being processed.
+func (l *Limiter) MoveToTimezone(ctx context.Context, telegramID int64, from, to *time.Location) error {
+	if l.getDateKey(from) == l.getDateKey(to) {
+		return nil
+	}
+
+	keys := []string{
+		l.getInFlightKey(telegramID),
+		l.getTokenKey(telegramID, from), l.getTokenKey(telegramID, to),
+		l.getRequestKey(telegramID, from), l.getRequestKey(telegramID, to),
+	}
+	busy, err := moveScript.Run(ctx, l.client, keys, time.Now().UnixMilli(), int(usageTTL.Seconds())).Int()
+	if err != nil {
+		return fmt.Errorf("failed to move usage to new timezone: %w", err)
+	}
+	if busy == 1 {
+		return ErrRequestsInFlight
+	}
+
+	return nil
+}
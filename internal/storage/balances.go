@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Balance sources
+const (
+	BalanceSubscription = "subscription"
+	BalanceTopUp        = "topup"
+)
+
+// Balance is one source of paid tokens: the user's subscription or a top-up pack.
+// Balances are consumed in a fixed order: the subscription first because its tokens
+// expire, then top-up packs, which never expire, oldest first.
+type Balance struct {
+	Source        string // BalanceSubscription or BalanceTopUp
+	ID            int64  // subscriptions.id or token_packs.id
+	PlanID        string
+	TokensGranted int
+	TokensUsed    int
+	ExpiresAt     time.Time // zero for top-ups
+}
+
+// Remaining returns how many tokens are left in the balance
+func (b *Balance) Remaining() int {
+	return b.TokensGranted - b.TokensUsed
+}
+
+// balancesQuery selects a user's non-empty balances in consumption order
+const balancesQuery = `
+	SELECT source, id, plan_id, tokens_granted, tokens_used, expires_at
+	FROM (
+		SELECT 'subscription' AS source, id, COALESCE(plan_id, '') AS plan_id,
+		       tokens_granted, tokens_used, expires_at, 0 AS rank, created_at
+		FROM subscriptions
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP AND tokens_used < tokens_granted
+		UNION ALL
+		SELECT 'topup', id, COALESCE(plan_id, ''),
+		       tokens_granted, tokens_used, NULL, 1, created_at
+		FROM token_packs
+		WHERE user_id = $1 AND tokens_used < tokens_granted
+	) balances
+	ORDER BY rank, created_at, id
+`
+
+// GetBalances returns the user's paid balances that still have tokens, in the order they are consumed
+func (s *Storage) GetBalances(ctx context.Context, userID int64) ([]*Balance, error) {
+	return balances(ctx, s.pool, userID)
+}
+
+// balances runs GetBalances on the pool or inside a transaction
+func balances(ctx context.Context, q querier, userID int64) ([]*Balance, error) {
+	rows, err := q.Query(ctx, balancesQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Balance
+	for rows.Next() {
+		b := &Balance{}
+		var expiresAt *time.Time
+		if err := rows.Scan(&b.Source, &b.ID, &b.PlanID, &b.TokensGranted, &b.TokensUsed, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		if expiresAt != nil {
+			b.ExpiresAt = *expiresAt
+		}
+		result = append(result, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	return result, nil
+}
+
+// PaidTokens returns the total tokens left across the user's balances
+func (s *Storage) PaidTokens(ctx context.Context, userID int64) (int, error) {
+	list, err := s.GetBalances(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, b := range list {
+		total += b.Remaining()
+	}
+	return total, nil
+}
+
+// ConsumeTokens deducts tokens from the user's balances in consumption order, in one
+// transaction. It returns the tokens that could not be covered because the balances
+// ran out; those are left to the caller.
+func (s *Storage) ConsumeTokens(ctx context.Context, userID int64, tokens int) (shortfall int, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin consume transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the user's balances so concurrent requests consume them one at a time
+	lockQuery := `SELECT id FROM users WHERE id = $1 FOR UPDATE`
+	if _, err := tx.Exec(ctx, lockQuery, userID); err != nil {
+		return 0, fmt.Errorf("failed to lock balances: %w", err)
+	}
+
+	list, err := balances(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := tokens
+	for _, b := range list {
+		if remaining == 0 {
+			break
+		}
+		take := min(remaining, b.Remaining())
+
+		table := "subscriptions"
+		if b.Source == BalanceTopUp {
+			table = "token_packs"
+		}
+		query := `UPDATE ` + table + ` SET tokens_used = tokens_used + $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+		if _, err := tx.Exec(ctx, query, take, b.ID); err != nil {
+			return 0, fmt.Errorf("failed to consume %s tokens: %w", b.Source, err)
+		}
+		if b.Source == BalanceSubscription {
+			if err := drainPeriods(ctx, tx, b.ID, take); err != nil {
+				return 0, err
+			}
+		}
+		remaining -= take
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit token consumption: %w", err)
+	}
+
+	return remaining, nil
+}
+
+// addTokenPack grants a top-up pack inside a payment transaction
+func addTokenPack(ctx context.Context, q querier, userID int64, planID string, paymentID int64, tokens int) (*Balance, error) {
+	b := &Balance{Source: BalanceTopUp, PlanID: planID, TokensGranted: tokens}
+
+	query := `
+		INSERT INTO token_packs (user_id, plan_id, payment_id, tokens_granted)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	if err := q.QueryRow(ctx, query, userID, planID, paymentID, tokens).Scan(&b.ID); err != nil {
+		return nil, fmt.Errorf("failed to add token pack: %w", err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Payment is one successful charge recorded in the payments ledger
+type Payment struct {
+	ID                      int64
+	UserID                  int64
+	TelegramID              int64 // payer; only set by RefundPayment
+	TelegramPaymentChargeID string
+	ProviderPaymentChargeID string
+	Currency                string
+	TotalAmount             int
+	InvoicePayload          string
+	PlanID                  string // empty if the payload named no known plan
+	SubscriptionID          int64  // 0 if the payment activated no subscription
+	CreatedAt               time.Time
+}
+
+// querier is what both the pool and a transaction offer for queries
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ProcessPayment records a payment and grants the plan it pays for in a single
+// transaction, so a charge is applied exactly once: a subscription plan activates or
+// renews the subscription following rules, a top-up plan adds a token pack. With a nil
+// plan the payment is only recorded. If the charge ID is already in the ledger (a
+// redelivered update), nothing changes and duplicate is true.
+func (s *Storage) ProcessPayment(ctx context.Context, payment *Payment, plan *Plan, rules RenewalRules) (granted *Balance, duplicate bool, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin payment transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	insertQuery := `
+		INSERT INTO payments (
+			user_id, telegram_payment_charge_id, provider_payment_charge_id,
+			currency, total_amount, invoice_payload, plan_id
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NULLIF($7, ''))
+		ON CONFLICT (telegram_payment_charge_id) DO NOTHING
+		RETURNING id, created_at
+	`
+	err = tx.QueryRow(ctx, insertQuery,
+		payment.UserID, payment.TelegramPaymentChargeID, payment.ProviderPaymentChargeID,
+		payment.Currency, payment.TotalAmount, payment.InvoicePayload, payment.PlanID,
+	).Scan(&payment.ID, &payment.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record payment: %w", err)
+	}
+
+	switch {
+	case plan == nil:
+	case plan.Kind == PlanKindTopUp:
+		granted, err = addTokenPack(ctx, tx, payment.UserID, plan.ID, payment.ID, plan.Tokens)
+		if err != nil {
+			return nil, false, err
+		}
+	default:
+		sub, _, err := renewSubscription(ctx, tx, payment.UserID, plan, payment.ID, rules)
+		if err != nil {
+			return nil, false, err
+		}
+
+		linkQuery := `UPDATE payments SET subscription_id = $1 WHERE id = $2`
+		if _, err := tx.Exec(ctx, linkQuery, sub.ID, payment.ID); err != nil {
+			return nil, false, fmt.Errorf("failed to link payment to subscription: %w", err)
+		}
+		payment.SubscriptionID = sub.ID
+
+		granted = &Balance{
+			Source:        BalanceSubscription,
+			ID:            sub.ID,
+			PlanID:        sub.PlanID,
+			TokensGranted: sub.TokensGranted,
+			TokensUsed:    sub.TokensUsed,
+			ExpiresAt:     sub.ExpiresAt,
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, fmt.Errorf("failed to commit payment: %w", err)
+	}
+
+	return granted, false, nil
+}
+
+// GetPaymentByChargeID looks up a payment by its Telegram charge ID, or returns nil if it is unknown
+func (s *Storage) GetPaymentByChargeID(ctx context.Context, chargeID string) (*Payment, error) {
+	payment := &Payment{}
+	query := `
+		SELECT id, user_id, telegram_payment_charge_id, COALESCE(provider_payment_charge_id, ''),
+		       currency, total_amount, COALESCE(invoice_payload, ''), COALESCE(plan_id, ''),
+		       COALESCE(subscription_id, 0), created_at
+		FROM payments
+		WHERE telegram_payment_charge_id = $1
+	`
+
+	err := s.pool.QueryRow(ctx, query, chargeID).Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.TelegramPaymentChargeID,
+		&payment.ProviderPaymentChargeID,
+		&payment.Currency,
+		&payment.TotalAmount,
+		&payment.InvoicePayload,
+		&payment.PlanID,
+		&payment.SubscriptionID,
+		&payment.CreatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return payment, nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Plan kinds
+const (
+	PlanKindSubscription = "subscription" // tokens valid for a period
+	PlanKindTopUp        = "topup"        // tokens that never expire
+)
+
+// Plan is a purchasable offer from the plans table
+type Plan struct {
+	ID          string
+	Kind        string
+	Title       string
+	Description string
+	PriceStars  int
+	Tokens      int
+	Duration    time.Duration // 0 for top-ups
+	Active      bool          // offered by /subscribe
+}
+
+// planColumns are selected by every plan query, in scanPlan order
+const planColumns = `id, kind, title, description, price_stars, tokens, COALESCE(duration_days, 0), active`
+
+// scanPlan reads a row selected with planColumns
+func scanPlan(row pgx.Row) (*Plan, error) {
+	plan := &Plan{}
+	var durationDays int
+	if err := row.Scan(&plan.ID, &plan.Kind, &plan.Title, &plan.Description, &plan.PriceStars, &plan.Tokens, &durationDays, &plan.Active); err != nil {
+		return nil, err
+	}
+	plan.Duration = time.Duration(durationDays) * 24 * time.Hour
+	return plan, nil
+}
+
+// ListPlans returns the active plans in the order they are offered
+func (s *Storage) ListPlans(ctx context.Context) ([]*Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE active ORDER BY sort_order, id`
+
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []*Plan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list plans: %w", err)
+	}
+
+	return plans, nil
+}
+
+// GetPlan returns a plan by ID, including withdrawn ones, or nil if it doesn't exist
+func (s *Storage) GetPlan(ctx context.Context, id string) (*Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE id = $1`
+
+	plan, err := scanPlan(s.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	return plan, nil
+}
@@ -22,6 +22,10 @@ type User struct {
 	Username   string
 	FirstName  string
 	LastName   string
+	Style      string
+	DecodeMode bool   // plain messages are decoded instead of rewritten
+	Banned     bool   // purchases are refused
+	Timezone   string // IANA name, e.g. "Europe/Berlin"
 	CreatedAt  time.Time
 	LastActive time.Time
 }
@@ -38,12 +42,26 @@ type UsageLog struct {
 	ResponsePreview string
 	Model           string
 	Success         bool
+	EstimatedTokens int    // tokens reserved up front
+	EstimateSource  string // how EstimatedTokens was obtained
+	ContextPreview  string // message the user replied to, empty for plain drafts
+	RequestType     string // one of the RequestType constants, RequestTypeRewrite if empty
 }
 
-// Subscription represents a paid monthly token package
+// Request types recorded in usage_logs, so the use of each mode can be compared
+const (
+	RequestTypeRewrite       = "rewrite"
+	RequestTypeDecode        = "decode"
+	RequestTypeInline        = "inline"
+	RequestTypeDocument      = "document"
+	RequestTypeTranscription = "transcription"
+)
+
+// Subscription represents a paid token package valid for a period
 type Subscription struct {
 	ID            int64
 	UserID        int64
+	PlanID        string
 	ExpiresAt     time.Time
 	TokensGranted int
 	TokensUsed    int
@@ -95,13 +113,13 @@ func (s *Storage) GetOrCreateUser(ctx context.Context, telegramID int64, usernam
 
 	// Try to get existing user
 	query := `
-		SELECT id, telegram_id, username, first_name, last_name, created_at, last_active
+		SELECT id, telegram_id, username, first_name, last_name, style, decode_mode, banned, timezone, created_at, last_active
 		FROM users
 		WHERE telegram_id = $1
 	`
 	err := s.pool.QueryRow(ctx, query, telegramID).Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.CreatedAt, &user.LastActive,
+		&user.LastName, &user.Style, &user.DecodeMode, &user.Banned, &user.Timezone, &user.CreatedAt, &user.LastActive,
 	)
 
 	if err == nil {
@@ -125,11 +143,11 @@ func (s *Storage) GetOrCreateUser(ctx context.Context, telegramID int64, usernam
 	insertQuery := `
 		INSERT INTO users (telegram_id, username, first_name, last_name)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, telegram_id, username, first_name, last_name, created_at, last_active
+		RETURNING id, telegram_id, username, first_name, last_name, style, decode_mode, banned, timezone, created_at, last_active
 	`
 	err = s.pool.QueryRow(ctx, insertQuery, telegramID, username, firstName, lastName).Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
-		&user.LastName, &user.CreatedAt, &user.LastActive,
+		&user.LastName, &user.Style, &user.DecodeMode, &user.Banned, &user.Timezone, &user.CreatedAt, &user.LastActive,
 	)
 
 	if err != nil {
@@ -140,19 +158,83 @@ func (s *Storage) GetOrCreateUser(ctx context.Context, telegramID int64, usernam
 	return user, nil
 }
 
+// SetUserDecodeMode turns reverse mode on or off for a user
+func (s *Storage) SetUserDecodeMode(ctx context.Context, telegramID int64, enabled bool) error {
+	query := `UPDATE users SET decode_mode = $1 WHERE telegram_id = $2`
+
+	tag, err := s.pool.Exec(ctx, query, enabled, telegramID)
+	if err != nil {
+		return fmt.Errorf("failed to set user decode mode: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set user decode mode: user %d not found", telegramID)
+	}
+
+	return nil
+}
+
+// SetUserStyle stores the rewrite style preset chosen by a user
+func (s *Storage) SetUserStyle(ctx context.Context, telegramID int64, style string) error {
+	query := `UPDATE users SET style = $1 WHERE telegram_id = $2`
+
+	tag, err := s.pool.Exec(ctx, query, style, telegramID)
+	if err != nil {
+		return fmt.Errorf("failed to set user style: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set user style: user %d not found", telegramID)
+	}
+
+	return nil
+}
+
+// Location returns the user's timezone, falling back to UTC if it can't be loaded
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		log.Printf("Warning: unknown timezone %q for user %d: %v", u.Timezone, u.TelegramID, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// SetUserTimezone stores the IANA timezone chosen by a user
+func (s *Storage) SetUserTimezone(ctx context.Context, telegramID int64, timezone string) error {
+	query := `UPDATE users SET timezone = $1 WHERE telegram_id = $2`
+
+	tag, err := s.pool.Exec(ctx, query, timezone, telegramID)
+	if err != nil {
+		return fmt.Errorf("failed to set user timezone: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set user timezone: user %d not found", telegramID)
+	}
+
+	return nil
+}
+
 // LogUsage records an API request in the database
 func (s *Storage) LogUsage(ctx context.Context, log *UsageLog) error {
 	query := `
 		INSERT INTO usage_logs (
 			user_id, input_tokens, output_tokens, total_tokens,
-			message_preview, response_preview, model, success
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			message_preview, response_preview, model, success,
+			estimated_tokens, estimate_source, context_preview, request_type
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12)
 		RETURNING id, timestamp
 	`
 
+	if log.RequestType == "" {
+		log.RequestType = RequestTypeRewrite
+	}
+
 	err := s.pool.QueryRow(ctx, query,
 		log.UserID, log.InputTokens, log.OutputTokens, log.TotalTokens,
 		log.MessagePreview, log.ResponsePreview, log.Model, log.Success,
+		log.EstimatedTokens, log.EstimateSource, log.ContextPreview, log.RequestType,
 	).Scan(&log.ID, &log.Timestamp)
 
 	if err != nil {
@@ -220,96 +302,81 @@ func (s *Subscription) RemainingTokens() int {
 	return s.TokensGranted - s.TokensUsed
 }
 
-// UpsertSubscription creates or renews a monthly subscription for a user
-func (s *Storage) UpsertSubscription(ctx context.Context, userID int64, tokensGranted int, duration time.Duration) (*Subscription, error) {
-	sub := &Subscription{}
-
-	query := `
-                INSERT INTO subscriptions (user_id, expires_at, tokens_granted, tokens_used)
-                VALUES ($1, CURRENT_TIMESTAMP + make_interval(secs => $2), $3, 0)
-                ON CONFLICT (user_id) DO UPDATE
-                SET expires_at = EXCLUDED.expires_at,
-                    tokens_granted = EXCLUDED.tokens_granted,
-                    tokens_used = 0,
-                    updated_at = CURRENT_TIMESTAMP
-                RETURNING id, user_id, expires_at, tokens_granted, tokens_used, created_at, updated_at
-        `
+// DefaultGroupCommands are the commands allowed in a group that has no saved settings.
+// /stats is left out: it posts the member's usage and paid balances to the whole group.
+var DefaultGroupCommands = []string{"polish", "help", "style"}
+
+// ChatSettings holds the group chat configuration managed by group admins
+type ChatSettings struct {
+	ChatID          int64
+	Enabled         bool
+	DefaultStyle    string // empty means each invoker's own style
+	TriggerWord     string // empty disables the trigger word
+	AllowedCommands []string
+	UpdatedAt       time.Time
+}
 
-	err := s.pool.QueryRow(ctx, query, userID, int64(duration.Seconds()), tokensGranted).Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.ExpiresAt,
-		&sub.TokensGranted,
-		&sub.TokensUsed,
-		&sub.CreatedAt,
-		&sub.UpdatedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to upsert subscription: %w", err)
+// AllowsCommand reports whether members may use the command in the group
+func (c *ChatSettings) AllowsCommand(command string) bool {
+	for _, allowed := range c.AllowedCommands {
+		if allowed == command {
+			return true
+		}
 	}
-
-	return sub, nil
+	return false
 }
 
-// GetActiveSubscription returns an active subscription for the user if it exists
-func (s *Storage) GetActiveSubscription(ctx context.Context, userID int64) (*Subscription, error) {
-	sub := &Subscription{}
+// GetChatSettings returns the settings of a group chat, or defaults if none were saved
+func (s *Storage) GetChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
+	settings := &ChatSettings{}
 	query := `
-                SELECT id, user_id, expires_at, tokens_granted, tokens_used, created_at, updated_at
-                FROM subscriptions
-                WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+                SELECT chat_id, enabled, default_style, trigger_word, allowed_commands, updated_at
+                FROM chat_settings
+                WHERE chat_id = $1
         `
 
-	err := s.pool.QueryRow(ctx, query, userID).Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.ExpiresAt,
-		&sub.TokensGranted,
-		&sub.TokensUsed,
-		&sub.CreatedAt,
-		&sub.UpdatedAt,
+	err := s.pool.QueryRow(ctx, query, chatID).Scan(
+		&settings.ChatID,
+		&settings.Enabled,
+		&settings.DefaultStyle,
+		&settings.TriggerWord,
+		&settings.AllowedCommands,
+		&settings.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
-		if err == pgxpool.ErrClosed {
-			return nil, fmt.Errorf("database connection closed: %w", err)
+			return &ChatSettings{
+				ChatID:          chatID,
+				Enabled:         true,
+				AllowedCommands: append([]string(nil), DefaultGroupCommands...),
+			}, nil
 		}
-		return nil, err
+		return nil, fmt.Errorf("failed to get chat settings: %w", err)
 	}
 
-	return sub, nil
+	return settings, nil
 }
 
-// ConsumeSubscriptionTokens deducts tokens from an active subscription if enough balance exists
-func (s *Storage) ConsumeSubscriptionTokens(ctx context.Context, userID int64, tokens int) (*Subscription, bool, error) {
-	sub := &Subscription{}
-
+// SaveChatSettings creates or updates the settings of a group chat
+func (s *Storage) SaveChatSettings(ctx context.Context, settings *ChatSettings) error {
 	query := `
-                UPDATE subscriptions
-                SET tokens_used = tokens_used + $1, updated_at = CURRENT_TIMESTAMP
-                WHERE user_id = $2
-                  AND expires_at > CURRENT_TIMESTAMP
-                  AND tokens_used + $1 <= tokens_granted
-                RETURNING id, user_id, expires_at, tokens_granted, tokens_used, created_at, updated_at
+                INSERT INTO chat_settings (chat_id, enabled, default_style, trigger_word, allowed_commands)
+                VALUES ($1, $2, $3, $4, $5)
+                ON CONFLICT (chat_id) DO UPDATE
+                SET enabled = EXCLUDED.enabled,
+                    default_style = EXCLUDED.default_style,
+                    trigger_word = EXCLUDED.trigger_word,
+                    allowed_commands = EXCLUDED.allowed_commands,
+                    updated_at = CURRENT_TIMESTAMP
+                RETURNING updated_at
         `
 
-	err := s.pool.QueryRow(ctx, query, tokens, userID).Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.ExpiresAt,
-		&sub.TokensGranted,
-		&sub.TokensUsed,
-		&sub.CreatedAt,
-		&sub.UpdatedAt,
-	)
+	err := s.pool.QueryRow(ctx, query,
+		settings.ChatID, settings.Enabled, settings.DefaultStyle, settings.TriggerWord, settings.AllowedCommands,
+	).Scan(&settings.UpdatedAt)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, false, nil
-		}
-		return nil, false, err
+		return fmt.Errorf("failed to save chat settings: %w", err)
 	}
 
-	return sub, true, nil
+	return nil
 }
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrPaymentNotFound means the charge ID is not in the ledger
+	ErrPaymentNotFound = errors.New("payment not found")
+	// ErrAlreadyRefunded means the payment was refunded before
+	ErrAlreadyRefunded = errors.New("payment already refunded")
+)
+
+// Refund statuses of a payment; an unrefunded payment has none
+const (
+	RefundStatusRefunding = "refunding"
+	RefundStatusRefunded  = "refunded"
+)
+
+// Refund describes why and by whom a payment is refunded
+type Refund struct {
+	Reason     string
+	RefundedBy int64 // Telegram ID of the admin, 0 for automatic refunds
+}
+
+// BeginRefund marks a payment as being refunded and returns it with TelegramID set.
+// It is the first of three steps, so the Stars are never returned inside a transaction:
+// the caller then returns the Stars, and CompleteRefund revokes the grants, or
+// AbortRefund clears the mark if Telegram refused. A payment already marked refunding,
+// e.g. by a crash between the steps, is returned as is so the refund can be finished.
+func (s *Storage) BeginRefund(ctx context.Context, chargeID string, refund Refund) (*Payment, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin refund transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	payment := &Payment{TelegramPaymentChargeID: chargeID}
+	var status string
+	query := `
+		SELECT p.id, p.user_id, u.telegram_id, p.currency, p.total_amount,
+		       COALESCE(p.plan_id, ''), COALESCE(p.subscription_id, 0), COALESCE(p.refund_status, '')
+		FROM payments p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.telegram_payment_charge_id = $1
+		FOR UPDATE OF p
+	`
+	err = tx.QueryRow(ctx, query, chargeID).Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.TelegramID,
+		&payment.Currency,
+		&payment.TotalAmount,
+		&payment.PlanID,
+		&payment.SubscriptionID,
+		&status,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	switch status {
+	case RefundStatusRefunded:
+		return payment, ErrAlreadyRefunded
+	case RefundStatusRefunding:
+		return payment, nil
+	}
+
+	markQuery := `
+		UPDATE payments
+		SET refund_status = $1, refund_reason = $2, refunded_by = NULLIF($3, 0)
+		WHERE id = $4
+	`
+	if _, err := tx.Exec(ctx, markQuery, RefundStatusRefunding, refund.Reason, refund.RefundedBy, payment.ID); err != nil {
+		return nil, fmt.Errorf("failed to mark payment refunding: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit refund start: %w", err)
+	}
+
+	return payment, nil
+}
+
+// BeginUnrecordedRefund records a payment that never made it into the ledger, e.g.
+// because activation kept failing, already marked refunding. A charge that is in the
+// ledger by now is begun like BeginRefund does.
+func (s *Storage) BeginUnrecordedRefund(ctx context.Context, payment *Payment, refund Refund) (*Payment, error) {
+	query := `
+		INSERT INTO payments (
+			user_id, telegram_payment_charge_id, provider_payment_charge_id,
+			currency, total_amount, invoice_payload, plan_id,
+			refund_status, refund_reason, refunded_by
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NULLIF($7, ''), $8, $9, NULLIF($10, 0))
+		ON CONFLICT (telegram_payment_charge_id) DO NOTHING
+		RETURNING id, created_at
+	`
+
+	err := s.pool.QueryRow(ctx, query,
+		payment.UserID, payment.TelegramPaymentChargeID, payment.ProviderPaymentChargeID,
+		payment.Currency, payment.TotalAmount, payment.InvoicePayload, payment.PlanID,
+		RefundStatusRefunding, refund.Reason, refund.RefundedBy,
+	).Scan(&payment.ID, &payment.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return s.BeginRefund(ctx, payment.TelegramPaymentChargeID, refund)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record refunded payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// CompleteRefund revokes what a refunding payment granted and marks it refunded, once
+// its Stars were returned. A subscription period loses its days and the tokens it still
+// contributes, a top-up pack its unused tokens. Returns the number of tokens revoked.
+func (s *Storage) CompleteRefund(ctx context.Context, paymentID int64) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin refund transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	var status string
+	query := `SELECT user_id, COALESCE(refund_status, '') FROM payments WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, paymentID).Scan(&userID, &status); err != nil {
+		return 0, fmt.Errorf("failed to get payment: %w", err)
+	}
+	switch status {
+	case RefundStatusRefunded:
+		return 0, ErrAlreadyRefunded
+	case "":
+		return 0, fmt.Errorf("payment %d has no refund in progress", paymentID)
+	}
+
+	// Lock the user's balances like ConsumeTokens does, so usage can't race the revocation
+	lockQuery := `SELECT id FROM users WHERE id = $1 FOR UPDATE`
+	if _, err := tx.Exec(ctx, lockQuery, userID); err != nil {
+		return 0, fmt.Errorf("failed to lock balances: %w", err)
+	}
+
+	revoked, err := revokeGrants(ctx, tx, paymentID)
+	if err != nil {
+		return 0, err
+	}
+
+	markQuery := `UPDATE payments SET refund_status = $1, refunded_at = CURRENT_TIMESTAMP WHERE id = $2`
+	if _, err := tx.Exec(ctx, markQuery, RefundStatusRefunded, paymentID); err != nil {
+		return 0, fmt.Errorf("failed to mark payment refunded: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit refund: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// AbortRefund clears the refunding mark of a payment whose Stars were not returned,
+// so it counts as an ordinary payment again
+func (s *Storage) AbortRefund(ctx context.Context, paymentID int64) error {
+	query := `
+		UPDATE payments
+		SET refund_status = NULL, refund_reason = NULL, refunded_by = NULL
+		WHERE id = $1 AND refund_status = $2
+	`
+	if _, err := s.pool.Exec(ctx, query, paymentID, RefundStatusRefunding); err != nil {
+		return fmt.Errorf("failed to abort refund: %w", err)
+	}
+	return nil
+}
+
+// revokeGrants takes back the subscription period or top-up pack bought by a payment
+// and returns the number of tokens revoked. Tokens already used are not taken back, and
+// neither are the tokens of other periods of the same subscription.
+func revokeGrants(ctx context.Context, tx pgx.Tx, paymentID int64) (int, error) {
+	revoked := 0
+
+	// Lock the subscription before its periods, in the order renewals and usage do
+	var subscriptionID int64
+	subQuery := `SELECT subscription_id FROM subscription_periods WHERE payment_id = $1 AND revoked_at IS NULL`
+	err := tx.QueryRow(ctx, subQuery, paymentID).Scan(&subscriptionID)
+	switch {
+	case err == pgx.ErrNoRows:
+	case err != nil:
+		return 0, fmt.Errorf("failed to get subscription period: %w", err)
+	default:
+		var active bool
+		lockQuery := `SELECT expires_at > CURRENT_TIMESTAMP FROM subscriptions WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRow(ctx, lockQuery, subscriptionID).Scan(&active); err != nil {
+			return 0, fmt.Errorf("failed to lock subscription: %w", err)
+		}
+
+		var (
+			periodID        int64
+			periodSeconds   float64
+			tokensRemaining int
+		)
+		periodQuery := `
+			SELECT id, EXTRACT(EPOCH FROM ends_at - starts_at), tokens_remaining
+			FROM subscription_periods
+			WHERE payment_id = $1 AND revoked_at IS NULL
+			FOR UPDATE
+		`
+		if err := tx.QueryRow(ctx, periodQuery, paymentID).Scan(&periodID, &periodSeconds, &tokensRemaining); err != nil {
+			return 0, fmt.Errorf("failed to get subscription period: %w", err)
+		}
+
+		// The end date moves back by the period's length, but never forward to now
+		reduceQuery := `
+			UPDATE subscriptions
+			SET expires_at = LEAST(expires_at, GREATEST(CURRENT_TIMESTAMP, expires_at - make_interval(secs => $1))),
+			    tokens_granted = tokens_granted - $2,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3
+		`
+		if _, err := tx.Exec(ctx, reduceQuery, periodSeconds, tokensRemaining, subscriptionID); err != nil {
+			return 0, fmt.Errorf("failed to reduce subscription: %w", err)
+		}
+		revokeQuery := `UPDATE subscription_periods SET tokens_remaining = 0, revoked_at = CURRENT_TIMESTAMP WHERE id = $1`
+		if _, err := tx.Exec(ctx, revokeQuery, periodID); err != nil {
+			return 0, fmt.Errorf("failed to revoke subscription period: %w", err)
+		}
+
+		// Tokens of an expired subscription were lost anyway
+		if active {
+			revoked += tokensRemaining
+		}
+	}
+
+	var packID int64
+	var packGranted, packUsed int
+	packQuery := `
+		SELECT id, tokens_granted, tokens_used
+		FROM token_packs
+		WHERE payment_id = $1 AND revoked_at IS NULL
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, packQuery, paymentID).Scan(&packID, &packGranted, &packUsed)
+	switch {
+	case err == pgx.ErrNoRows:
+	case err != nil:
+		return 0, fmt.Errorf("failed to get token pack: %w", err)
+	default:
+		revokeQuery := `
+			UPDATE token_packs
+			SET tokens_granted = tokens_used, revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`
+		if _, err := tx.Exec(ctx, revokeQuery, packID); err != nil {
+			return 0, fmt.Errorf("failed to revoke token pack: %w", err)
+		}
+		revoked += packGranted - packUsed
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestStorage connects to the database in TEST_DATABASE_URL, which must have the
+// migrations applied, and creates a user that is deleted with everything it bought
+// when the test ends. The test is skipped without a database.
+func newTestStorage(t *testing.T) (*Storage, *User) {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	store, err := New(databaseURL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx := context.Background()
+	user, err := store.GetOrCreateUser(ctx, -1-rand.Int63n(1<<40), "refund_test", "Refund", "Test")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	t.Cleanup(func() {
+		for _, query := range []string{
+			`DELETE FROM subscription_periods WHERE user_id = $1`,
+			`DELETE FROM token_packs WHERE user_id = $1`,
+			`UPDATE payments SET subscription_id = NULL WHERE user_id = $1`,
+			`DELETE FROM subscriptions WHERE user_id = $1`,
+			`DELETE FROM payments WHERE user_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, err := store.pool.Exec(ctx, query, user.ID); err != nil {
+				t.Errorf("cleanup: %v", err)
+			}
+		}
+		store.Close()
+	})
+
+	return store, user
+}
+
+// buy processes a payment for plan and returns its charge ID
+func buy(t *testing.T, store *Storage, user *User, plan *Plan, rules RenewalRules) string {
+	t.Helper()
+
+	chargeID := fmt.Sprintf("test_%d_%d", user.ID, rand.Int63())
+	payment := &Payment{
+		UserID:                  user.ID,
+		TelegramPaymentChargeID: chargeID,
+		Currency:                "XTR",
+		TotalAmount:             plan.PriceStars,
+		PlanID:                  plan.ID,
+	}
+	if _, duplicate, err := store.ProcessPayment(context.Background(), payment, plan, rules); err != nil || duplicate {
+		t.Fatalf("ProcessPayment: duplicate %v, %v", duplicate, err)
+	}
+	return chargeID
+}
+
+// refund runs both steps of a refund whose Stars are assumed returned
+func refund(t *testing.T, store *Storage, chargeID string) int {
+	t.Helper()
+
+	ctx := context.Background()
+	payment, err := store.BeginRefund(ctx, chargeID, Refund{Reason: "test"})
+	if err != nil {
+		t.Fatalf("BeginRefund: %v", err)
+	}
+	revoked, err := store.CompleteRefund(ctx, payment.ID)
+	if err != nil {
+		t.Fatalf("CompleteRefund: %v", err)
+	}
+	return revoked
+}
+
+// subscription returns the user's subscription balance
+func subscription(t *testing.T, store *Storage, user *User) *Balance {
+	t.Helper()
+
+	list, err := store.GetBalances(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("GetBalances: %v", err)
+	}
+	for _, b := range list {
+		if b.Source == BalanceSubscription {
+			return b
+		}
+	}
+	return nil
+}
+
+func testPlan(t *testing.T, store *Storage) *Plan {
+	t.Helper()
+
+	plan, err := store.GetPlan(context.Background(), "monthly")
+	if err != nil || plan == nil {
+		t.Fatalf("GetPlan(monthly): %v, %v", plan, err)
+	}
+	if plan.Kind != PlanKindSubscription {
+		t.Fatalf("plan monthly is a %s, want a subscription", plan.Kind)
+	}
+	return plan
+}
+
+func TestRefundOlderPeriodKeepsStackedRenewal(t *testing.T) {
+	store, user := newTestStorage(t)
+	ctx := context.Background()
+	plan := testPlan(t, store)
+	rules := RenewalRules{Stack: true, CarryOverPercent: 100}
+
+	// Period A: use all but 100 tokens
+	first := buy(t, store, user, plan, rules)
+	if shortfall, err := store.ConsumeTokens(ctx, user.ID, plan.Tokens-100); err != nil || shortfall != 0 {
+		t.Fatalf("ConsumeTokens: shortfall %d, %v", shortfall, err)
+	}
+	afterFirst := subscription(t, store, user)
+
+	// Period B stacks on A and carries A's last 100 tokens over
+	buy(t, store, user, plan, rules)
+	if sub := subscription(t, store, user); sub.Remaining() != plan.Tokens+100 {
+		t.Fatalf("after renewal %d tokens remain, want %d", sub.Remaining(), plan.Tokens+100)
+	}
+
+	// Refunding A only takes back what A still contributes
+	if revoked := refund(t, store, first); revoked != 100 {
+		t.Errorf("revoked %d tokens, want the 100 period A still held", revoked)
+	}
+	sub := subscription(t, store, user)
+	if sub == nil || sub.Remaining() != plan.Tokens {
+		t.Fatalf("after refunding A the subscription is %+v, want period B's %d tokens intact", sub, plan.Tokens)
+	}
+	if diff := sub.ExpiresAt.Sub(afterFirst.ExpiresAt); diff < -time.Minute || diff > time.Minute {
+		t.Errorf("after refunding A the subscription expires %s, want %s when only B's days are left", sub.ExpiresAt, afterFirst.ExpiresAt)
+	}
+
+	periods, err := store.GetSubscriptionPeriods(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetSubscriptionPeriods: %v", err)
+	}
+	if len(periods) != 2 || periods[1].RevokedAt == nil || periods[0].RevokedAt != nil {
+		t.Errorf("want period A revoked and period B active, got %+v, %+v", periods[0], periods[1])
+	}
+
+	// A second refund of the same payment changes nothing
+	if _, err := store.BeginRefund(ctx, first, Refund{Reason: "test"}); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Errorf("second BeginRefund: got %v, want ErrAlreadyRefunded", err)
+	}
+}
+
+func TestRefundNewerPeriodKeepsCarriedOverTokens(t *testing.T) {
+	store, user := newTestStorage(t)
+	ctx := context.Background()
+	plan := testPlan(t, store)
+	rules := RenewalRules{Stack: true, CarryOverPercent: 100}
+
+	buy(t, store, user, plan, rules)
+	if _, err := store.ConsumeTokens(ctx, user.ID, plan.Tokens-100); err != nil {
+		t.Fatalf("ConsumeTokens: %v", err)
+	}
+	second := buy(t, store, user, plan, rules)
+
+	// Usage drains the carried-over tokens of A first, then 50 of B's
+	if _, err := store.ConsumeTokens(ctx, user.ID, 150); err != nil {
+		t.Fatalf("ConsumeTokens: %v", err)
+	}
+
+	if revoked := refund(t, store, second); revoked != plan.Tokens-50 {
+		t.Errorf("revoked %d tokens, want the %d period B still held", revoked, plan.Tokens-50)
+	}
+	if sub := subscription(t, store, user); sub != nil {
+		t.Errorf("nothing should be left after refunding B, got %+v", sub)
+	}
+}
+
+func TestAbortRefundKeepsPayment(t *testing.T) {
+	store, user := newTestStorage(t)
+	ctx := context.Background()
+	plan := testPlan(t, store)
+
+	chargeID := buy(t, store, user, plan, RenewalRules{Stack: true, CarryOverPercent: 100})
+	payment, err := store.BeginRefund(ctx, chargeID, Refund{Reason: "test"})
+	if err != nil {
+		t.Fatalf("BeginRefund: %v", err)
+	}
+	if err := store.AbortRefund(ctx, payment.ID); err != nil {
+		t.Fatalf("AbortRefund: %v", err)
+	}
+
+	if _, err := store.CompleteRefund(ctx, payment.ID); err == nil {
+		t.Error("CompleteRefund succeeded after the refund was aborted")
+	}
+	if sub := subscription(t, store, user); sub == nil || sub.Remaining() != plan.Tokens {
+		t.Errorf("an aborted refund must leave the subscription alone, got %+v", sub)
+	}
+}
+
+func TestRefundLatestPeriodAfterCarryOverFromLegacySubscription(t *testing.T) {
+	store, user := newTestStorage(t)
+	ctx := context.Background()
+	plan := testPlan(t, store)
+	rules := RenewalRules{Stack: true, CarryOverPercent: 100}
+
+	// A subscription from before 013, with 100 tokens left and the period 012 recorded for it
+	var subscriptionID int64
+	legacyQuery := `
+		INSERT INTO subscriptions (user_id, plan_id, expires_at, tokens_granted, tokens_used)
+		VALUES ($1, $2, CURRENT_TIMESTAMP + INTERVAL '10 days', 1000, 900)
+		RETURNING id
+	`
+	if err := store.pool.QueryRow(ctx, legacyQuery, user.ID, plan.ID).Scan(&subscriptionID); err != nil {
+		t.Fatalf("insert legacy subscription: %v", err)
+	}
+	periodQuery := `
+		INSERT INTO subscription_periods (subscription_id, user_id, plan_id, starts_at, ends_at, tokens_granted, tokens_remaining)
+		SELECT id, user_id, plan_id, expires_at - INTERVAL '30 days', expires_at, tokens_granted, 0
+		FROM subscriptions WHERE id = $1
+	`
+	if _, err := store.pool.Exec(ctx, periodQuery, subscriptionID); err != nil {
+		t.Fatalf("insert legacy period: %v", err)
+	}
+
+	// Apply 013 again: its backfill gives the unused tokens to that period
+	migration, err := os.ReadFile("../../migrations/013_refunds.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+	if _, err := store.pool.Exec(ctx, string(migration)); err != nil {
+		t.Fatalf("apply migration: %v", err)
+	}
+
+	// The renewal carries the 100 tokens over; usage drains them before the new period's
+	second := buy(t, store, user, plan, rules)
+	if _, err := store.ConsumeTokens(ctx, user.ID, 150); err != nil {
+		t.Fatalf("ConsumeTokens: %v", err)
+	}
+
+	if revoked := refund(t, store, second); revoked != plan.Tokens-50 {
+		t.Errorf("revoked %d tokens, want the %d the new period still held", revoked, plan.Tokens-50)
+	}
+	if sub := subscription(t, store, user); sub != nil {
+		t.Errorf("nothing should be left after refunding the new period, got %+v", sub)
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RenewalRules decide what happens to a subscription that is renewed while still active
+type RenewalRules struct {
+	// Stack starts the new period at the current end date instead of now, so paid-for
+	// days are kept
+	Stack bool
+	// CarryOverPercent is the share of unused tokens kept into the new period, 0-100
+	CarryOverPercent int
+}
+
+// SubscriptionPeriod is one purchased period of a subscription
+type SubscriptionPeriod struct {
+	ID                int64
+	SubscriptionID    int64
+	PlanID            string
+	PaymentID         int64
+	StartsAt          time.Time
+	EndsAt            time.Time
+	TokensGranted     int
+	TokensCarriedOver int
+	TokensForfeited   int
+	TokensRemaining   int        // unused tokens of this period still in the subscription
+	RevokedAt         *time.Time // set when the period's payment was refunded
+}
+
+// renewSubscription creates the user's subscription or renews it with a new period of
+// plan inside a payment transaction. An expired subscription starts over now; an active
+// one is renewed according to rules. The subscriptions row keeps the running total and
+// the period is recorded in subscription_periods.
+func renewSubscription(ctx context.Context, tx pgx.Tx, userID int64, plan *Plan, paymentID int64, rules RenewalRules) (*Subscription, *SubscriptionPeriod, error) {
+	var now time.Time
+	if err := tx.QueryRow(ctx, `SELECT CURRENT_TIMESTAMP`).Scan(&now); err != nil {
+		return nil, nil, fmt.Errorf("failed to read database time: %w", err)
+	}
+
+	current := &Subscription{}
+	currentQuery := `
+		SELECT id, expires_at, tokens_granted, tokens_used
+		FROM subscriptions
+		WHERE user_id = $1
+		FOR UPDATE
+	`
+	err := tx.QueryRow(ctx, currentQuery, userID).Scan(&current.ID, &current.ExpiresAt, &current.TokensGranted, &current.TokensUsed)
+	if err == pgx.ErrNoRows {
+		current = nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to read subscription: %w", err)
+	}
+
+	period := &SubscriptionPeriod{
+		PlanID:        plan.ID,
+		PaymentID:     paymentID,
+		StartsAt:      now,
+		TokensGranted: plan.Tokens,
+	}
+	if current != nil && current.ExpiresAt.After(now) {
+		if rules.Stack {
+			period.StartsAt = current.ExpiresAt
+		}
+		unused := max(current.RemainingTokens(), 0)
+		period.TokensCarriedOver = unused * rules.CarryOverPercent / 100
+		period.TokensForfeited = unused - period.TokensCarriedOver
+	}
+	period.EndsAt = period.StartsAt.Add(plan.Duration)
+
+	// Carried-over tokens stay with the periods that granted them: forfeited ones are
+	// taken oldest first, and nothing is left of an expired subscription
+	switch {
+	case current == nil:
+	case current.ExpiresAt.After(now):
+		if err := drainPeriods(ctx, tx, current.ID, period.TokensForfeited); err != nil {
+			return nil, nil, err
+		}
+	default:
+		expireQuery := `UPDATE subscription_periods SET tokens_remaining = 0 WHERE subscription_id = $1 AND tokens_remaining > 0`
+		if _, err := tx.Exec(ctx, expireQuery, current.ID); err != nil {
+			return nil, nil, fmt.Errorf("failed to expire subscription periods: %w", err)
+		}
+	}
+
+	sub := &Subscription{}
+	upsertQuery := `
+		INSERT INTO subscriptions (user_id, plan_id, expires_at, tokens_granted, tokens_used)
+		VALUES ($1, $2, $3, $4, 0)
+		ON CONFLICT (user_id) DO UPDATE
+		SET plan_id = EXCLUDED.plan_id,
+		    expires_at = EXCLUDED.expires_at,
+		    tokens_granted = EXCLUDED.tokens_granted,
+		    tokens_used = 0,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING id, user_id, COALESCE(plan_id, ''), expires_at, tokens_granted, tokens_used, created_at, updated_at
+	`
+	err = tx.QueryRow(ctx, upsertQuery, userID, plan.ID, period.EndsAt, period.TokensGranted+period.TokensCarriedOver).Scan(
+		&sub.ID,
+		&sub.UserID,
+		&sub.PlanID,
+		&sub.ExpiresAt,
+		&sub.TokensGranted,
+		&sub.TokensUsed,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to upsert subscription: %w", err)
+	}
+
+	period.SubscriptionID = sub.ID
+	periodQuery := `
+		INSERT INTO subscription_periods (
+			subscription_id, user_id, plan_id, payment_id, starts_at, ends_at,
+			tokens_granted, tokens_carried_over, tokens_forfeited, tokens_remaining
+		) VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7, $8, $9, $7)
+		RETURNING id
+	`
+	err = tx.QueryRow(ctx, periodQuery,
+		sub.ID, userID, plan.ID, paymentID, period.StartsAt, period.EndsAt,
+		period.TokensGranted, period.TokensCarriedOver, period.TokensForfeited,
+	).Scan(&period.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to record subscription period: %w", err)
+	}
+	period.TokensRemaining = period.TokensGranted
+
+	return sub, period, nil
+}
+
+// drainPeriods takes tokens from the remaining tokens of a subscription's periods, oldest
+// period first, inside a transaction that holds the subscription's row lock. It follows
+// usage and forfeits of the subscription, so each period knows what it still contributes.
+func drainPeriods(ctx context.Context, tx pgx.Tx, subscriptionID int64, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+
+	query := `
+		UPDATE subscription_periods p
+		SET tokens_remaining = p.tokens_remaining - LEAST(p.tokens_remaining, $2 - older.tokens)
+		FROM (
+			SELECT id, SUM(tokens_remaining) OVER (ORDER BY starts_at, id) - tokens_remaining AS tokens
+			FROM subscription_periods
+			WHERE subscription_id = $1 AND tokens_remaining > 0
+		) older
+		WHERE p.id = older.id AND older.tokens < $2
+	`
+	if _, err := tx.Exec(ctx, query, subscriptionID, tokens); err != nil {
+		return fmt.Errorf("failed to update subscription periods: %w", err)
+	}
+
+	return nil
+}
+
+// GetSubscriptionPeriods returns the periods a user bought, newest first
+func (s *Storage) GetSubscriptionPeriods(ctx context.Context, userID int64) ([]*SubscriptionPeriod, error) {
+	query := `
+		SELECT id, subscription_id, COALESCE(plan_id, ''), COALESCE(payment_id, 0), starts_at, ends_at,
+		       tokens_granted, tokens_carried_over, tokens_forfeited, tokens_remaining, revoked_at
+		FROM subscription_periods
+		WHERE user_id = $1
+		ORDER BY starts_at DESC, id DESC
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription periods: %w", err)
+	}
+	defer rows.Close()
+
+	var periods []*SubscriptionPeriod
+	for rows.Next() {
+		p := &SubscriptionPeriod{}
+		err := rows.Scan(&p.ID, &p.SubscriptionID, &p.PlanID, &p.PaymentID, &p.StartsAt, &p.EndsAt,
+			&p.TokensGranted, &p.TokensCarriedOver, &p.TokensForfeited, &p.TokensRemaining, &p.RevokedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription period: %w", err)
+		}
+		periods = append(periods, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get subscription periods: %w", err)
+	}
+
+	return periods, nil
+}
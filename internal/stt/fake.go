@@ -0,0 +1,34 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+)
+
+// Fake returns a fixed transcript without calling any service. It is meant for
+// local development and tests where no speech-to-text backend is available.
+type Fake struct {
+	Text string
+	Err  error
+}
+
+// NewFake creates a fake backend that always recognizes text
+func NewFake(text string) *Fake {
+	return &Fake{Text: text}
+}
+
+// Transcribe returns the configured text or error
+func (f *Fake) Transcribe(ctx context.Context, audio Audio) (*Transcript, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	text := f.Text
+	if text == "" {
+		text = fmt.Sprintf("(fake transcript of a %s recording)", audio.Duration)
+	}
+	return &Transcript{Text: text, Model: "fake"}, nil
+}
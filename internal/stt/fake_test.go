@@ -0,0 +1,43 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeTranscribe(t *testing.T) {
+	audio := Audio{FileName: "voice.ogg", MimeType: "audio/ogg", Duration: 42 * time.Second}
+
+	transcript, err := NewFake("please send the report").Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if transcript.Text != "please send the report" || transcript.Model != "fake" {
+		t.Errorf("got %+v, want the configured text from model fake", transcript)
+	}
+
+	// Without a configured text the transcript describes the recording
+	transcript, err = NewFake("").Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if !strings.Contains(transcript.Text, "42s") {
+		t.Errorf("placeholder transcript %q doesn't mention the duration", transcript.Text)
+	}
+}
+
+func TestFakeTranscribeErrors(t *testing.T) {
+	failure := errors.New("backend down")
+	if _, err := (&Fake{Err: failure}).Transcribe(context.Background(), Audio{}); !errors.Is(err, failure) {
+		t.Errorf("got %v, want the configured error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewFake("text").Transcribe(ctx, Audio{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
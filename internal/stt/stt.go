@@ -0,0 +1,25 @@
+package stt
+
+import (
+	"context"
+	"time"
+)
+
+// Audio is a recording to transcribe
+type Audio struct {
+	Data     []byte
+	FileName string
+	MimeType string
+	Duration time.Duration // as reported by Telegram
+}
+
+// Transcript is the text recognized in a recording
+type Transcript struct {
+	Text  string
+	Model string // backend model that produced the transcript, for usage logs
+}
+
+// Transcriber turns speech into text. Implementations must be safe for concurrent use.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio Audio) (*Transcript, error)
+}
@@ -0,0 +1,100 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Whisper transcribes audio through an OpenAI-compatible /audio/transcriptions endpoint
+type Whisper struct {
+	apiURL     string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+// whisperResponse is the JSON reply of the transcription endpoint
+type whisperResponse struct {
+	Text string `json:"text"`
+}
+
+// whisperError is the error envelope of OpenAI-compatible APIs
+type whisperError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewWhisper creates a Whisper backend. apiKey may be empty for self-hosted servers.
+func NewWhisper(apiURL, apiKey, model string, httpClient *http.Client) *Whisper {
+	return &Whisper{
+		apiURL:     apiURL,
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: httpClient,
+	}
+}
+
+// Transcribe uploads the recording as multipart form data
+func (w *Whisper) Transcribe(ctx context.Context, audio Audio) (*Transcript, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	fileName := audio.FileName
+	if fileName == "" {
+		fileName = "voice.ogg"
+	}
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build transcription request: %w", err)
+	}
+	if _, err := part.Write(audio.Data); err != nil {
+		return nil, fmt.Errorf("failed to build transcription request: %w", err)
+	}
+	form.WriteField("model", w.model)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build transcription request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.apiURL, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcription request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+w.apiKey)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send transcription request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcription response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr whisperError
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("transcription failed with status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("transcription failed with status %d", resp.StatusCode)
+	}
+
+	var result whisperResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transcription response: %w", err)
+	}
+
+	return &Transcript{Text: strings.TrimSpace(result.Text), Model: w.model}, nil
+}
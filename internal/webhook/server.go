@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// secretHeader carries the secret token Telegram echoes back on every webhook call
+const secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds the request body of a single update
+const maxUpdateSize = 1 << 20
+
+// Server receives Telegram updates over HTTPS and hands them to a dispatch function
+type Server struct {
+	httpServer *http.Server
+	secret     string
+	dispatch   func(tgbotapi.Update)
+}
+
+// New creates a webhook server listening on addr. Updates posted to path with the
+// right secret token are passed to dispatch; /healthz answers load balancer checks.
+func New(addr, path, secret string, dispatch func(tgbotapi.Update)) *Server {
+	s := &Server{
+		secret:   secret,
+		dispatch: dispatch,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handleUpdate)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	return s
+}
+
+// ListenAndServe serves until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	log.Printf("Webhook server listening on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("webhook server failed: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting updates and waits for in-flight requests
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleUpdate validates and decodes one update. It answers right away;
+// the dispatch function is expected to process the update asynchronously.
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(secretHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) != 1 {
+		log.Printf("Rejected webhook call from %s: bad secret token", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxUpdateSize))
+	if err != nil {
+		log.Printf("Error reading webhook body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.Unmarshal(body, &update); err != nil {
+		log.Printf("Error decoding webhook update: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.dispatch(update)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Register points Telegram at publicURL and sets the secret token it must send back.
+// setWebhook is called directly because the library's WebhookConfig has no secret_token.
+func Register(bot *tgbotapi.BotAPI, publicURL, secret string, maxConnections int) error {
+	if _, err := url.Parse(publicURL); err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+
+	params := tgbotapi.Params{
+		"url":          publicURL,
+		"secret_token": secret,
+	}
+	if maxConnections > 0 {
+		params["max_connections"] = strconv.Itoa(maxConnections)
+	}
+
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	log.Printf("Webhook registered at %s", publicURL)
+	return nil
+}
+
+// Delete removes the webhook so Telegram stops pushing updates
+func Delete(bot *tgbotapi.BotAPI) error {
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	log.Println("Webhook deleted")
+	return nil
+}
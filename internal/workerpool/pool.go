@@ -0,0 +1,188 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Submit once Shutdown has started
+var ErrClosed = errors.New("worker pool is shutting down")
+
+// cancelGrace is how long Shutdown waits for cancelled jobs before warning about them
+const cancelGrace = 5 * time.Second
+
+// Job is a unit of work. ctx is cancelled when shutdown runs out of time.
+type Job func(ctx context.Context)
+
+// Pool runs jobs on a fixed number of workers. Jobs that share a key run one
+// at a time in submission order; jobs with different keys run in parallel.
+type Pool struct {
+	mu     sync.Mutex
+	queues map[string][]Job // pending jobs per key; a key is present while it is scheduled
+	closed bool
+
+	ready   chan string   // keys with pending jobs waiting for a worker
+	slots   chan struct{} // bounds the number of queued + running jobs
+	quit    chan struct{}
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New starts a pool with the given number of workers. At most queueSize jobs
+// may be queued or running; Submit blocks while the pool is full.
+func New(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < workers {
+		queueSize = workers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		queues: make(map[string][]Job),
+		ready:  make(chan string, queueSize),
+		slots:  make(chan struct{}, queueSize),
+		quit:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit queues job behind any pending jobs with the same key. While the pool is full it
+// blocks until a slot frees up, the pool shuts down or ctx is done, returning ctx's error then.
+func (p *Pool) Submit(ctx context.Context, key string, job Job) error {
+	// Reserve capacity first so a full pool applies backpressure to the caller.
+	// A free slot is taken even if ctx is already done.
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.quit:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return ErrClosed
+	}
+
+	p.pending.Add(1)
+	_, scheduled := p.queues[key]
+	p.queues[key] = append(p.queues[key], job)
+	p.mu.Unlock()
+
+	// The ready channel has room for every slot, so this never blocks
+	if !scheduled {
+		p.ready <- key
+	}
+
+	return nil
+}
+
+// work runs jobs key by key until the pool quits
+func (p *Pool) work() {
+	defer p.workers.Done()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case key := <-p.ready:
+			p.drain(key)
+		}
+	}
+}
+
+// drain runs the jobs of one key in order until its queue is empty
+func (p *Pool) drain(key string) {
+	for {
+		p.mu.Lock()
+		queue := p.queues[key]
+		if len(queue) == 0 {
+			delete(p.queues, key)
+			p.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		p.queues[key] = queue[1:]
+		p.mu.Unlock()
+
+		p.run(key, job)
+	}
+}
+
+// run executes one job, skipping it if shutdown already cancelled the pool
+func (p *Pool) run(key string, job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in job %s: %v", key, r)
+		}
+		<-p.slots
+		p.pending.Done()
+	}()
+
+	if p.ctx.Err() != nil {
+		log.Printf("Dropping job %s: pool is shutting down", key)
+		return
+	}
+
+	job(p.ctx)
+}
+
+// Shutdown stops accepting jobs and waits for queued and running jobs to finish.
+// If ctx expires first, running jobs are cancelled, jobs that haven't started are
+// dropped, and Shutdown returns ctx's error once the running jobs have returned.
+// It never returns while a job still runs, so callers can close what jobs use.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Println("Shutdown deadline reached, cancelling in-flight jobs")
+		p.cancel()
+
+		select {
+		case <-done:
+		case <-time.After(cancelGrace):
+			// Jobs bound their own detached work (billing, payments), so this ends
+			log.Println("Some jobs did not stop after cancellation, still waiting for them")
+			<-done
+		}
+	}
+
+	p.cancel()
+	close(p.quit)
+	p.workers.Wait()
+	return err
+}
@@ -0,0 +1,233 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor fails the test if ch isn't closed or sent to within a second
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// shutdown stops the pool at the end of a test, waiting for its jobs
+func shutdown(t *testing.T, p *Pool) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestSameKeyRunsSeriallyInOrder(t *testing.T) {
+	const jobs = 20
+	p := New(4, jobs)
+
+	var (
+		mu      sync.Mutex
+		order   []int
+		running atomic.Int32
+		overlap atomic.Bool
+	)
+	for i := 0; i < jobs; i++ {
+		err := p.Submit(context.Background(), "user:1", func(ctx context.Context) {
+			if running.Add(1) > 1 {
+				overlap.Store(true)
+			}
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			running.Add(-1)
+		})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	shutdown(t, p)
+
+	if overlap.Load() {
+		t.Error("jobs with the same key ran at the same time")
+	}
+	if len(order) != jobs {
+		t.Fatalf("ran %d jobs, want %d", len(order), jobs)
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("jobs ran in order %v, want submission order", order)
+		}
+	}
+}
+
+func TestDifferentKeysRunConcurrentlyUpToWorkers(t *testing.T) {
+	const workers = 3
+	p := New(workers, 10)
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var running, peak atomic.Int32
+	for i := 0; i < workers+2; i++ {
+		err := p.Submit(context.Background(), fmt.Sprintf("user:%d", i), func(ctx context.Context) {
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			started <- struct{}{}
+			<-release
+			running.Add(-1)
+		})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	// Every worker picks up a key of its own
+	for i := 0; i < workers; i++ {
+		waitFor(t, started, fmt.Sprintf("job %d to start", i+1))
+	}
+	select {
+	case <-started:
+		t.Fatalf("a job started with all %d workers busy", workers)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	shutdown(t, p)
+
+	if got := peak.Load(); got != workers {
+		t.Errorf("at most %d jobs ran at once, want %d", got, workers)
+	}
+}
+
+func TestSubmitReturnsWhenContextIsDoneOnFullPool(t *testing.T) {
+	p := New(1, 1)
+	release := make(chan struct{})
+	if err := p.Submit(context.Background(), "a", func(ctx context.Context) { <-release }); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- p.Submit(ctx, "b", func(ctx context.Context) {})
+	}()
+
+	select {
+	case err := <-result:
+		t.Fatalf("Submit on a full pool returned %v, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Submit after cancel: got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit kept blocking after its context was cancelled")
+	}
+
+	close(release)
+	shutdown(t, p)
+}
+
+func TestSubmitTakesFreeSlotWithDoneContext(t *testing.T) {
+	p := New(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ran := make(chan struct{})
+	if err := p.Submit(ctx, "a", func(ctx context.Context) { close(ran) }); err != nil {
+		t.Fatalf("Submit with a free slot: %v", err)
+	}
+	waitFor(t, ran, "the job to run")
+	shutdown(t, p)
+}
+
+func TestShutdownWaitsForJobs(t *testing.T) {
+	p := New(2, 4)
+
+	var finished atomic.Int32
+	for i := 0; i < 4; i++ {
+		err := p.Submit(context.Background(), fmt.Sprintf("user:%d", i), func(ctx context.Context) {
+			time.Sleep(20 * time.Millisecond)
+			if ctx.Err() == nil {
+				finished.Add(1)
+			}
+		})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	shutdown(t, p)
+	if got := finished.Load(); got != 4 {
+		t.Errorf("%d jobs finished before Shutdown returned, want 4", got)
+	}
+
+	if err := p.Submit(context.Background(), "late", func(ctx context.Context) {}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Shutdown: got %v, want ErrClosed", err)
+	}
+}
+
+func TestShutdownCancelsJobsAtDeadline(t *testing.T) {
+	p := New(1, 2)
+
+	started := make(chan struct{})
+	var cancelled, finished atomic.Bool
+	err := p.Submit(context.Background(), "slow", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		cancelled.Store(true)
+		// Cleanup after cancellation still runs to completion
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	var dropped atomic.Bool
+	dropped.Store(true)
+	if err := p.Submit(context.Background(), "queued", func(ctx context.Context) { dropped.Store(false) }); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	waitFor(t, started, "the slow job to start")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	err = p.Shutdown(ctx)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown: got %v, want context.DeadlineExceeded", err)
+	}
+	if !cancelled.Load() {
+		t.Error("the running job was not cancelled at the deadline")
+	}
+	if !finished.Load() {
+		t.Error("Shutdown returned while a cancelled job was still running")
+	}
+	if !dropped.Load() {
+		t.Error("a job that hadn't started ran after the deadline")
+	}
+	if elapsed := time.Since(begin); elapsed > cancelGrace {
+		t.Errorf("Shutdown took %s, more than the deadline plus %s", elapsed, cancelGrace)
+	}
+}
@@ -0,0 +1,5 @@
+-- Per-user rewrite style presets
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS style VARCHAR(50) NOT NULL DEFAULT 'default';
+
+COMMENT ON COLUMN users.style IS 'Selected rewrite style preset (see prompts/styles)';
@@ -0,0 +1,16 @@
+-- Per-group settings for group chat mode
+
+CREATE TABLE IF NOT EXISTS chat_settings (
+    chat_id BIGINT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    default_style VARCHAR(50) NOT NULL DEFAULT '',
+    trigger_word VARCHAR(64) NOT NULL DEFAULT '',
+    allowed_commands TEXT[] NOT NULL DEFAULT ARRAY['polish', 'help', 'style'],
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE chat_settings IS 'Group chat configuration managed by group admins via /groupsettings';
+COMMENT ON COLUMN chat_settings.default_style IS 'Style preset for the group; empty means each invoker''s own style';
+COMMENT ON COLUMN chat_settings.trigger_word IS 'Opt-in word that triggers a rewrite; empty disables it';
+COMMENT ON COLUMN chat_settings.allowed_commands IS 'Bot commands members may use in the group';
@@ -0,0 +1,5 @@
+-- Per-user timezone for daily limit resets
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+COMMENT ON COLUMN users.timezone IS 'IANA timezone name; daily token limits reset at local midnight';
@@ -0,0 +1,10 @@
+-- Token estimates recorded next to the actual usage
+
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS estimated_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS estimate_source VARCHAR(20);
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS estimate_gap INTEGER
+    GENERATED ALWAYS AS (total_tokens - estimated_tokens) STORED;
+
+COMMENT ON COLUMN usage_logs.estimated_tokens IS 'Tokens reserved before the request, from the estimator';
+COMMENT ON COLUMN usage_logs.estimate_source IS 'count_tokens or heuristic';
+COMMENT ON COLUMN usage_logs.estimate_gap IS 'Actual minus estimated tokens; positive means the reservation was too small';
@@ -0,0 +1,5 @@
+-- Quoted or forwarded message a request replied to, logged apart from the draft
+
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS context_preview TEXT;
+
+COMMENT ON COLUMN usage_logs.context_preview IS 'First 500 chars of the message the user replied to, if any';
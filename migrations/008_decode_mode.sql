@@ -0,0 +1,14 @@
+-- Reverse mode (corporate speak → plain language) and request types in the usage log
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS decode_mode BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN users.decode_mode IS 'Plain text messages are decoded instead of rewritten (toggled with /decode)';
+
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS request_type VARCHAR(20) NOT NULL DEFAULT 'rewrite';
+
+-- Transcriptions were the only non-rewrite requests logged so far
+UPDATE usage_logs SET request_type = 'transcription' WHERE model LIKE 'stt:%';
+
+CREATE INDEX IF NOT EXISTS idx_usage_logs_request_type ON usage_logs(request_type, timestamp);
+
+COMMENT ON COLUMN usage_logs.request_type IS 'rewrite, decode, inline, document or transcription';
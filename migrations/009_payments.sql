@@ -0,0 +1,22 @@
+-- Ledger of Telegram Stars payments; the charge ID makes processing exactly-once
+
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    telegram_payment_charge_id VARCHAR(255) NOT NULL,
+    provider_payment_charge_id VARCHAR(255),
+    currency VARCHAR(10) NOT NULL,
+    total_amount INTEGER NOT NULL,
+    invoice_payload TEXT,
+    subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (telegram_payment_charge_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_user_id ON payments(user_id);
+CREATE INDEX IF NOT EXISTS idx_payments_created_at ON payments(created_at);
+
+COMMENT ON TABLE payments IS 'Every successful payment, recorded in the same transaction as the activation it paid for';
+COMMENT ON COLUMN payments.telegram_payment_charge_id IS 'Telegram charge ID; a redelivered update with the same ID is not activated again';
+COMMENT ON COLUMN payments.total_amount IS 'Amount in the smallest units of the currency (Stars for XTR)';
+COMMENT ON COLUMN payments.subscription_id IS 'Subscription activated or renewed by this payment';
@@ -0,0 +1,5 @@
+-- Banned users cannot buy subscriptions
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN users.banned IS 'Set by hand for abusive accounts; pre-checkout rejects their purchases';
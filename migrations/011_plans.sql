@@ -0,0 +1,50 @@
+-- Plan catalog (monthly tiers, weekly pass, top-up packs) and non-expiring token packs
+
+CREATE TABLE IF NOT EXISTS plans (
+    id VARCHAR(50) PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('subscription', 'topup')),
+    title VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    price_stars INTEGER NOT NULL CHECK (price_stars > 0),
+    tokens INTEGER NOT NULL CHECK (tokens > 0),
+    duration_days INTEGER CHECK (duration_days > 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((kind = 'subscription') = (duration_days IS NOT NULL))
+);
+
+COMMENT ON TABLE plans IS 'Offers shown by /subscribe; edit rows to change prices, deactivate to withdraw a plan';
+COMMENT ON COLUMN plans.kind IS 'subscription: tokens valid for duration_days; topup: tokens that never expire';
+COMMENT ON COLUMN plans.id IS 'Stable ID used in invoice payloads; must not contain a colon';
+
+-- "monthly" is the original $5 offer: 4M tokens, what Claude Haiku 4.5 gives on a $3 budget
+INSERT INTO plans (id, kind, title, description, price_stars, tokens, duration_days, sort_order) VALUES
+    ('monthly_lite', 'subscription', 'Monthly Lite', '1.5M tokens for 30 days', 150, 1500000, 30, 10),
+    ('monthly', 'subscription', 'Monthly', '4M tokens for 30 days', 325, 4000000, 30, 20),
+    ('monthly_pro', 'subscription', 'Monthly Pro', '9M tokens for 30 days', 650, 9000000, 30, 30),
+    ('weekly', 'subscription', 'Weekly pass', '1M tokens for 7 days', 100, 1000000, 7, 40),
+    ('topup_small', 'topup', 'Top-up 500K', '500K tokens that never expire', 75, 500000, NULL, 50),
+    ('topup_large', 'topup', 'Top-up 2M', '2M tokens that never expire', 250, 2000000, NULL, 60)
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS plan_id VARCHAR(50) REFERENCES plans(id);
+UPDATE subscriptions SET plan_id = 'monthly' WHERE plan_id IS NULL;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS plan_id VARCHAR(50) REFERENCES plans(id);
+
+CREATE TABLE IF NOT EXISTS token_packs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id VARCHAR(50) REFERENCES plans(id),
+    payment_id BIGINT REFERENCES payments(id),
+    tokens_granted INTEGER NOT NULL,
+    tokens_used INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (tokens_used <= tokens_granted)
+);
+
+CREATE INDEX IF NOT EXISTS idx_token_packs_open ON token_packs(user_id, created_at) WHERE tokens_used < tokens_granted;
+
+COMMENT ON TABLE token_packs IS 'Non-expiring top-up packs; used after the subscription, oldest first';
@@ -0,0 +1,32 @@
+-- One row per purchased subscription period, so renewals can stack and stay auditable
+
+CREATE TABLE IF NOT EXISTS subscription_periods (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id VARCHAR(50) REFERENCES plans(id),
+    payment_id BIGINT REFERENCES payments(id),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    tokens_granted INTEGER NOT NULL,
+    tokens_carried_over INTEGER NOT NULL DEFAULT 0,
+    tokens_forfeited INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_periods_subscription ON subscription_periods(subscription_id, starts_at);
+CREATE INDEX IF NOT EXISTS idx_subscription_periods_payment ON subscription_periods(payment_id);
+
+COMMENT ON TABLE subscription_periods IS 'Purchased subscription periods; subscriptions holds the running total across them';
+COMMENT ON COLUMN subscription_periods.starts_at IS 'Current end of the subscription when a renewal was stacked, otherwise the purchase time';
+COMMENT ON COLUMN subscription_periods.tokens_granted IS 'Tokens of the plan bought for this period';
+COMMENT ON COLUMN subscription_periods.tokens_carried_over IS 'Unused tokens of the running subscription kept at renewal';
+COMMENT ON COLUMN subscription_periods.tokens_forfeited IS 'Unused tokens of the running subscription dropped at renewal';
+
+-- Existing subscriptions predate period tracking and were all 30-day monthly passes:
+-- record their current period as bought
+INSERT INTO subscription_periods (subscription_id, user_id, plan_id, starts_at, ends_at, tokens_granted, created_at)
+SELECT s.id, s.user_id, s.plan_id, s.expires_at - INTERVAL '30 days', s.expires_at, s.tokens_granted, s.expires_at - INTERVAL '30 days'
+FROM subscriptions s
+WHERE NOT EXISTS (SELECT 1 FROM subscription_periods p WHERE p.subscription_id = s.id);
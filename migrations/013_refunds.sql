@@ -0,0 +1,39 @@
+-- Star refunds recorded in the payments ledger, and the grants they revoked
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refund_reason TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_by BIGINT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refund_status VARCHAR(20);
+
+COMMENT ON COLUMN payments.refunded_at IS 'When the Stars were returned with refundStarPayment; NULL if not refunded';
+COMMENT ON COLUMN payments.refund_reason IS 'Why the payment was refunded';
+COMMENT ON COLUMN payments.refunded_by IS 'Telegram ID of the admin who issued the refund; NULL for automatic refunds';
+COMMENT ON COLUMN payments.refund_status IS 'NULL if not refunded, refunding while the Stars are being returned, refunded once the grants are revoked';
+
+ALTER TABLE subscription_periods ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE subscription_periods ADD COLUMN IF NOT EXISTS tokens_remaining INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE token_packs ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+
+COMMENT ON COLUMN subscription_periods.revoked_at IS 'Set when the payment for this period was refunded';
+COMMENT ON COLUMN subscription_periods.tokens_remaining IS 'Unused tokens of this period still in the subscription, including those carried into later periods; the oldest period is used first';
+COMMENT ON COLUMN token_packs.revoked_at IS 'Set when the payment for this pack was refunded';
+
+-- Periods recorded by 012 for existing subscriptions start out holding nothing: give
+-- each active subscription's unused tokens to its newest period. Subscriptions whose
+-- periods already track their tokens are left alone, so the migration can run again.
+UPDATE subscription_periods p
+SET tokens_remaining = CASE
+        WHEN p.id = latest.id THEN GREATEST(s.tokens_granted - s.tokens_used, 0)
+        ELSE 0
+    END
+FROM subscriptions s
+CROSS JOIN LATERAL (
+    SELECT id FROM subscription_periods
+    WHERE subscription_id = s.id AND revoked_at IS NULL
+    ORDER BY starts_at DESC, id DESC
+    LIMIT 1
+) latest
+WHERE p.subscription_id = s.id
+  AND p.revoked_at IS NULL
+  AND s.expires_at > CURRENT_TIMESTAMP
+  AND NOT EXISTS (SELECT 1 FROM subscription_periods q WHERE q.subscription_id = s.id AND q.tokens_remaining > 0);